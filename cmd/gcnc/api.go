@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -15,7 +16,9 @@ import (
 	sse "github.com/alexandrevicenzi/go-sse"
 	"github.com/jasonwbarnett/fileserver"
 	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
 	"github.com/mastercactapus/gcnc/machine"
+	"github.com/mastercactapus/gcnc/meshlevel"
 )
 
 type api struct {
@@ -23,20 +26,28 @@ type api struct {
 	m       *machine.Machine
 	dataDir string
 	sse     *sse.Server
+
+	toolTable  *toolTable
+	jobManager *jobManager
 }
 
 func newAPI(m *machine.Machine, dir string) *api {
 	mux := http.NewServeMux()
 
 	a := &api{
-		Handler: mux,
-		m:       m,
-		dataDir: dir,
+		Handler:   mux,
+		m:         m,
+		dataDir:   dir,
+		toolTable: newToolTable(dir),
 		sse: sse.NewServer(&sse.Options{
 			Logger: log.New(ioutil.Discard, "", 0),
 		}),
 	}
 
+	a.jobManager = newJobManager(a.sse)
+	m.SetTools(a.toolTable)
+	a.configureToolChange()
+
 	fs := fileserver.New(http.Dir(dir))
 	mux.Handle("/data/", http.StripPrefix("/data", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
@@ -54,9 +65,31 @@ func newAPI(m *machine.Machine, dir string) *api {
 	})))
 
 	mux.HandleFunc("/api/run", a.run)
+	mux.HandleFunc("/api/jobs", a.jobs)
+	mux.HandleFunc("/api/jobs/", a.jobs)
 	mux.HandleFunc("/api/probe", a.probe)
+	mux.HandleFunc("/api/probe/xy", a.probeXY)
+	mux.HandleFunc("/api/grid/report", a.gridReport)
+	mux.HandleFunc("/api/grid/exclude", a.gridExclude)
+	mux.HandleFunc("/api/grid/reprobe", a.gridReprobe)
+	mux.HandleFunc("/api/meshes", a.meshes)
+	mux.HandleFunc("/api/meshes/", a.meshes)
+
+	mux.HandleFunc("/api/holds", a.holds)
+	mux.HandleFunc("/api/holds/", a.holds)
 
 	mux.HandleFunc("/api/tool/change", a.toolChange)
+	mux.HandleFunc("/api/tool/reference", a.toolReference)
+	mux.HandleFunc("/api/tool/config", a.toolChangeConfig)
+	mux.HandleFunc("/api/tools", a.tools)
+	mux.HandleFunc("/api/tools/", a.tools)
+
+	mux.HandleFunc("/api/raster", a.raster)
+	mux.HandleFunc("/api/relief", a.relief)
+
+	mux.HandleFunc("/api/preview", a.preview)
+	mux.HandleFunc("/api/toolpath", a.toolpath)
+	mux.HandleFunc("/api/simulate", a.simulate)
 
 	mux.Handle("/events/", a.sse)
 	go func() {
@@ -71,8 +104,13 @@ func newAPI(m *machine.Machine, dir string) *api {
 	}()
 
 	go func() {
-		for msg := range m.HoldMessage() {
-			a.sse.SendMessage("/events/hold", sse.SimpleMessage(msg))
+		for h := range m.HoldMessage() {
+			data, err := json.Marshal(h)
+			if err != nil {
+				log.Printf("ERROR: marshal json: %+v", err)
+				continue
+			}
+			a.sse.SendMessage("/events/hold", sse.SimpleMessage(string(data)))
 		}
 	}()
 
@@ -92,40 +130,117 @@ func safePath(base, name string) (bool, string) {
 	return true, fullName
 }
 
-func (a *api) run(w http.ResponseWriter, req *http.Request) {
-	if req.Method != "POST" {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-		return
+// readGrid will read the points of the requested mesh in machine coordinates,
+// skipping any that did not make contact, and where Z was zeroed while probing it, if known.
+func (a *api) readGrid(q url.Values) ([]coord.Point, *coord.Point, error) {
+	mf, err := a.readMesh(a.meshName(q))
+	if err != nil {
+		return nil, nil, err
 	}
+	wco := a.m.CurrentState().WCO
+	return mf.MachinePoints(wco), mf.MachineZero(wco), nil
+}
 
+// levelOptions will parse leveling options from a query string.
+//
+// It returns false if leveling was not requested with "gridLevel" (max segment length)
+// or "tolerance" (max Z error).
+func levelOptions(q url.Values) (bool, machine.LevelOptions, error) {
+	var opt machine.LevelOptions
+	if q.Get("gridLevel") == "" && q.Get("tolerance") == "" {
+		return false, opt, nil
+	}
 	var err error
-	grid := req.URL.Query().Get("gridLevel")
-	if grid != "" {
-		lvl, err := strconv.ParseFloat(grid, 64)
+	if q.Get("gridLevel") != "" {
+		opt.Granularity, err = strconv.ParseFloat(q.Get("gridLevel"), 64)
 		if err != nil {
-			http.Error(w, err.Error(), 400)
-			return
-		}
-		ok, gridFile := safePath(a.dataDir, "grid.json")
-		if !ok {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
+			return false, opt, err
 		}
-
-		data, err := ioutil.ReadFile(gridFile)
+	}
+	if q.Get("tolerance") != "" {
+		opt.Tolerance, err = strconv.ParseFloat(q.Get("tolerance"), 64)
 		if err != nil {
-			log.Println("ERROR: read grid.json:", err)
-			http.Error(w, err.Error(), 400)
-			return
+			return false, opt, err
 		}
+	}
+
+	opt.Edge, err = meshlevel.ParseEdgeMode(q.Get("edge"))
+	if err != nil {
+		return false, opt, err
+	}
+	opt.Model, err = meshlevel.ParseModel(q.Get("model"))
+	if err != nil {
+		return false, opt, err
+	}
+
+	return true, opt, nil
+}
+
+// programReader will open a program from the data directory starting
+// from the current machine position, leveling it against the probe grid
+// if requested by q.
+func (a *api) programReader(name string, q url.Values) (gcode.Reader, io.Closer, error) {
+	ok, fullName := safePath(a.dataDir, name)
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	lvl, opt, err := levelOptions(q)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(fullName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !lvl {
+		return gcode.NewParser(f), f, nil
+	}
+
+	points, zero, err := a.readGrid(q)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	z, err := meshlevel.NewZOffsetter(opt.Model, points)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	stat := a.m.CurrentState()
+	return meshlevel.New(meshlevel.Config{
+		ZOffsetter:  z,
+		MPos:        stat.MPos,
+		WCO:         stat.WCO,
+		Zero:        zero,
+		Granularity: opt.Granularity,
+		Tolerance:   opt.Tolerance,
+		Edge:        opt.Edge,
+		Reader:      gcode.NewParser(f),
+	}), f, nil
+}
+
+func (a *api) run(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	grid := req.URL.Query().Get("gridLevel")
+	lvl, opt, err := levelOptions(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if lvl {
 		var gridData []coord.Point
-		err = json.Unmarshal(data, &gridData)
+		gridData, opt.Zero, err = a.readGrid(req.URL.Query())
 		if err != nil {
-			log.Println("ERROR: parse grid.json:", err)
-			http.Error(w, err.Error(), 500)
+			log.Println("ERROR: read mesh:", err)
+			http.Error(w, err.Error(), 400)
 			return
 		}
-		_, err = a.m.ReadFromLevel(req.Body, lvl, gridData)
+		_, err = a.m.ReadFromLevel(req.Body, opt, gridData)
 	} else {
 		_, err = a.m.ReadFrom(req.Body)
 	}
@@ -143,9 +258,9 @@ func (a *api) probe(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	ok, name := safePath(a.dataDir, "grid.json")
-	if !ok {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	meshName := a.meshName(req.URL.Query())
+	if !validMeshName(meshName) {
+		http.Error(w, "invalid mesh name", http.StatusBadRequest)
 		return
 	}
 
@@ -155,6 +270,7 @@ func (a *api) probe(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var res interface{}
+	var mf *machine.MeshFile
 	grid := req.URL.Query().Get("grid") == "1"
 	if grid {
 		var opt machine.ProbeGridOptions
@@ -164,7 +280,54 @@ func (a *api) probe(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		res, err = a.m.ProbeZGrid(opt)
+		if req.URL.Query().Get("file") != "" {
+			err = a.fitProbeArea(req.URL.Query(), &opt)
+			if err != nil {
+				log.Println("ERROR: fit probe area:", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// zeroing Z changes the work offset, so the mesh is stored relative to the one probing ended with
+		var progress *machine.GridProgress
+		if req.URL.Query().Get("resume") == "1" {
+			progress, err = a.readPartial(meshName)
+			if err == nil {
+				err = progress.Resume(&opt)
+			}
+			if err != nil {
+				log.Println("ERROR: resume grid:", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			progress = machine.NewGridProgress(&opt, a.m.CurrentState().MPos)
+		}
+		opt.OnProbe = func(r machine.ProbeResult) {
+			progress.Results = append(progress.Results, r)
+			err := a.writePartial(meshName, progress)
+			if err != nil {
+				log.Println("ERROR: write partial grid:", err)
+			}
+			data, err := json.Marshal(r)
+			if err != nil {
+				log.Printf("ERROR: marshal json: %+v", err)
+				return
+			}
+			a.sse.SendMessage("/events/probe", sse.SimpleMessage(string(data)))
+		}
+
+		var probes []machine.ProbeResult
+		var wco coord.Point
+		probes, wco, err = a.m.ProbeZGrid(opt)
+		res = probes
+		mf = machine.NewMeshFile(meshName, wco, opt, probes)
+		if progress.Zero != nil {
+			zero := progress.Zero.Sub(wco)
+			zero.Z = opt.Offset
+			mf.Zero = &zero
+		}
 	} else {
 		var opt machine.ProbeOptions
 		err = json.Unmarshal(data, &opt)
@@ -181,21 +344,28 @@ func (a *api) probe(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	out := io.Writer(w)
 	if grid {
-		os.MkdirAll(filepath.Dir(name), 0755)
-		f, err := os.Create(name)
+		err = a.writeMesh(mf)
 		if err != nil {
-			log.Printf("ERROR: create '%s': %+v", name, err)
+			log.Printf("ERROR: write mesh '%s': %+v", meshName, err)
 		} else {
-			defer f.Close()
-			out = io.MultiWriter(w, f)
+			a.removePartial(meshName)
 		}
 	}
-	err = json.NewEncoder(out).Encode(res)
+	err = json.NewEncoder(w).Encode(res)
 	if err != nil {
 		log.Println("ERROR: encode:", err)
 	}
+
+	if grid {
+		points, valid := machine.SplitProbes(mf.Points)
+		q, err := meshlevel.Analyze(points, valid, meshlevel.AnalyzeOptions{})
+		if err != nil {
+			log.Println("ERROR: analyze grid:", err)
+		} else if len(q.Outliers) > 0 {
+			log.Printf("WARNING: grid probe has %d outliers, see /api/grid/report", len(q.Outliers))
+		}
+	}
 }
 
 func (a *api) putFile(w http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/machine"
+	"github.com/mastercactapus/gcnc/meshlevel"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// analyzeGrid will analyze the current grid with the threshold from the request, if any.
+func (a *api) analyzeGrid(req *http.Request) (*meshlevel.Quality, error) {
+	var opt meshlevel.AnalyzeOptions
+	if t := req.URL.Query().Get("threshold"); t != "" {
+		var err error
+		opt.Threshold, err = strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mf, err := a.readMesh(a.meshName(req.URL.Query()))
+	if err != nil {
+		return nil, err
+	}
+	points, valid := machine.SplitProbes(mf.Points)
+	return meshlevel.Analyze(points, valid, opt)
+}
+
+func (a *api) gridReport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	a.writeGridReport(w, req)
+}
+
+// writeGridReport will respond with the quality report for the current grid.
+func (a *api) writeGridReport(w http.ResponseWriter, req *http.Request) {
+	q, err := a.analyzeGrid(req)
+	if err != nil {
+		log.Println("ERROR: analyze grid:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	err = json.NewEncoder(w).Encode(q)
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
+}
+
+// readIndexes will read a JSON array of probe result indexes from the request body.
+func readIndexes(req *http.Request, n int) ([]int, error) {
+	var idx []int
+	err := json.NewDecoder(req.Body).Decode(&idx)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range idx {
+		if i < 0 || i >= n {
+			return nil, errors.New("invalid point index: " + strconv.Itoa(i))
+		}
+	}
+	return idx, nil
+}
+
+// gridExclude will remove points, by index, from the grid.
+func (a *api) gridExclude(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	mf, err := a.readMesh(a.meshName(req.URL.Query()))
+	if err != nil {
+		log.Println("ERROR: read mesh:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res := mf.Points
+	idx, err := readIndexes(req, len(res))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	remove := make(map[int]bool, len(idx))
+	for _, i := range idx {
+		remove[i] = true
+	}
+	keep := res[:0]
+	for i, r := range res {
+		if !remove[i] {
+			keep = append(keep, r)
+		}
+	}
+
+	mf.Points = keep
+	err = a.writeMesh(mf)
+	if err != nil {
+		log.Println("ERROR: write mesh:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	a.writeGridReport(w, req)
+}
+
+// gridReprobe will probe points of the grid again, by index.
+//
+// The request body is the ProbeOptions to use, with the indexes of the points to re-probe in "Points".
+func (a *api) gridReprobe(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		machine.ProbeOptions
+		Points []int
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	mf, err := a.readMesh(a.meshName(req.URL.Query()))
+	if err != nil {
+		log.Println("ERROR: read mesh:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res := mf.Points
+
+	wco := a.m.CurrentState().WCO
+	xy := make([]coord.Point, len(body.Points))
+	zHeight := math.Inf(-1)
+	for n, i := range body.Points {
+		if i < 0 || i >= len(res) {
+			http.Error(w, "invalid point index: "+strconv.Itoa(i), 400)
+			return
+		}
+		xy[n] = res[i].Add(wco)
+	}
+	for _, r := range res {
+		if r.Valid {
+			zHeight = math.Max(zHeight, r.Z)
+		}
+	}
+	if math.IsInf(zHeight, -1) {
+		http.Error(w, "no valid points in grid", 400)
+		return
+	}
+
+	probes, err := a.m.ProbeZPoints(xy, body.ProbeOptions, zHeight+wco.Z+0.2)
+	if err != nil {
+		log.Println("ERROR: re-probe:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for n, i := range body.Points {
+		res[i] = machine.ProbeResult{Point: probes[n].Sub(wco), Valid: probes[n].Valid}
+	}
+	mf.Time = time.Now()
+
+	err = a.writeMesh(mf)
+	if err != nil {
+		log.Println("ERROR: write mesh:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	a.writeGridReport(w, req)
+}
+
+// fitProbeArea will set the grid area from the footprint of a program.
+//
+// The first probe is taken at the current position, so it must be within the area.
+//
+// The "area" parameter selects the whole bounding box ("bounds", the default) or only
+// points within "margin" of a cutting move ("cut"). Margin defaults to the grid granularity.
+func (a *api) fitProbeArea(q url.Values, opt *machine.ProbeGridOptions) error {
+	margin := opt.Granularity
+	if q.Get("margin") != "" {
+		var err error
+		margin, err = strconv.ParseFloat(q.Get("margin"), 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	segs, err := a.readToolpath(q.Get("file"), nil)
+	if err != nil {
+		return err
+	}
+	f := toolpath.NewFootprint(segs, margin)
+	if f == nil {
+		return errors.New("program has no cutting moves")
+	}
+
+	opt.Origin = &coord.Point{X: f.Min.X - margin, Y: f.Min.Y - margin}
+	opt.DistanceX = f.Max.X - f.Min.X + 2*margin
+	opt.DistanceY = f.Max.Y - f.Min.Y + 2*margin
+
+	switch q.Get("area") {
+	case "", "bounds":
+	case "cut":
+		opt.Include = f.Contains
+	default:
+		return errors.New("unknown area: " + q.Get("area"))
+	}
+
+	// the first probe (and Z zero) is at the current position, it must be on the work
+	pos := a.m.CurrentState().MPos
+	inside := pos.X >= opt.Origin.X && pos.X <= opt.Origin.X+opt.DistanceX &&
+		pos.Y >= opt.Origin.Y && pos.Y <= opt.Origin.Y+opt.DistanceY
+	if !inside || (opt.Include != nil && !opt.Include(pos.X, pos.Y)) {
+		return errors.New("current position is outside of the probe area")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mastercactapus/gcnc/machine"
+)
+
+// holds handles the operator prompt API:
+//
+//	GET  /api/holds      list active holds
+//	POST /api/holds/{id} resolve a hold with an optional {"Choice": "..."} body, and resume
+func (a *api) holds(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/holds"), "/")
+	if path == "" {
+		if req.Method != "GET" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		err := json.NewEncoder(w).Encode(a.m.Holds())
+		if err != nil {
+			log.Println("ERROR: encode:", err)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(path)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct{ Choice string }
+	if req.ContentLength != 0 {
+		err = json.NewDecoder(req.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	err = a.m.ResolveHold(id, body.Choice)
+	if err == machine.ErrUnknownHold {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: resolve hold %d: %+v", id, err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sse "github.com/alexandrevicenzi/go-sse"
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// estimateRapid is the rapid rate, in mm/min, used to estimate run times.
+const estimateRapid = 1000
+
+// defaultSpindleDelay is the time, in seconds, to wait for the spindle to start when resuming a job.
+const defaultSpindleDelay = 3
+
+var errJobStopped = errors.New("job stopped")
+
+type jobState string
+
+const (
+	jobRunning  jobState = "running"
+	jobPaused   jobState = "paused"
+	jobStopping jobState = "stopping"
+	jobStopped  jobState = "stopped"
+	jobDone     jobState = "done"
+	jobFailed   jobState = "failed"
+)
+
+// job is a program running in the background.
+type job struct {
+	ID    int
+	File  string
+	State jobState
+
+	// Line is the source line last sent to the machine, of Lines total.
+	Line, Lines int
+
+	// ResumeLine is the line the job was started from, if not the beginning.
+	ResumeLine int `json:",omitempty"`
+
+	Percent float64
+	Started time.Time
+	ETA     *time.Time `json:",omitempty"`
+	Error   string     `json:",omitempty"`
+
+	safeZ    float64
+	est      *toolpath.Estimate
+	startAt  time.Duration
+	stopping bool
+
+	// time spent paused, and since when if currently paused
+	paused      time.Duration
+	pausedSince time.Time
+}
+
+// jobManager runs one job at a time, keeping a history of past jobs.
+type jobManager struct {
+	mx   sync.Mutex
+	sse  *sse.Server
+	jobs []*job
+	cur  *job
+
+	lastEvent time.Time
+}
+
+func newJobManager(s *sse.Server) *jobManager {
+	return &jobManager{sse: s}
+}
+
+// publish will send the state of j as an event; line updates are limited to 4 per second.
+//
+// mx must be held.
+func (jm *jobManager) publish(j *job, force bool) {
+	if !force && time.Since(jm.lastEvent) < 250*time.Millisecond {
+		return
+	}
+	jm.lastEvent = time.Now()
+	data, err := json.Marshal(j)
+	if err != nil {
+		log.Printf("ERROR: marshal json: %+v", err)
+		return
+	}
+	jm.sse.SendMessage("/events/job", sse.SimpleMessage(string(data)))
+}
+
+// progress will update j for source line.
+//
+// mx must be held.
+func (j *job) progress(line int) {
+	j.Line = line
+	if j.est != nil && j.est.Total > 0 {
+		j.Percent = float64(j.est.At(line)) / float64(j.est.Total) * 100
+	} else if j.Lines > 0 {
+		j.Percent = float64(line) / float64(j.Lines) * 100
+	}
+
+	j.ETA = nil
+	elapsed := time.Since(j.Started) - j.paused
+	if j.est == nil || j.est.At(line) <= j.startAt || j.State != jobRunning {
+		return
+	}
+	// scale the remaining estimate by how the job has actually run so far
+	scale := float64(elapsed) / float64(j.est.At(line)-j.startAt)
+	eta := time.Now().Add(time.Duration(float64(j.est.Total-j.est.At(line)) * scale))
+	j.ETA = &eta
+}
+
+// jobReader feeds a program to the machine one line at a time, tracking progress.
+//
+// Adapters stop streaming at any read error without returning it, so the last error
+// (other than io.EOF) is kept in err.
+type jobReader struct {
+	jm  *jobManager
+	j   *job
+	gr  gcode.Reader
+	buf bytes.Buffer
+	err error
+}
+
+func (r *jobReader) Read(p []byte) (int, error) {
+	if r.buf.Len() == 0 {
+		r.jm.mx.Lock()
+		stopping := r.j.stopping
+		r.jm.mx.Unlock()
+		if stopping {
+			r.err = errJobStopped
+			return 0, r.err
+		}
+
+		b, err := r.gr.Read()
+		if err != nil {
+			if err != io.EOF {
+				r.err = err
+			}
+			return 0, err
+		}
+		if lr, ok := r.gr.(gcode.LineReader); ok {
+			r.jm.mx.Lock()
+			r.j.progress(lr.Line())
+			r.jm.publish(r.j, false)
+			r.jm.mx.Unlock()
+		}
+		r.buf.WriteString(b.String() + "\n")
+	}
+	return r.buf.Read(p)
+}
+
+// countLines will return the number of lines in a file.
+func countLines(name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	var n int
+	for s.Scan() {
+		n++
+	}
+	return n, s.Err()
+}
+
+// startJob will run a program from the data directory in the background.
+func (a *api) startJob(name string, q url.Values) (*job, error) {
+	ok, fullName := safePath(a.dataDir, name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	lines, err := countLines(fullName)
+	if err != nil {
+		return nil, err
+	}
+	j := &job{File: name, Lines: lines, State: jobRunning}
+	j.safeZ, err = a.safeZ(q)
+	if err != nil {
+		return nil, err
+	}
+
+	segs, err := a.readToolpath(name, q)
+	if err != nil {
+		log.Printf("ERROR: estimate '%s': %+v", name, err)
+	} else {
+		j.est = toolpath.NewEstimate(segs, estimateRapid)
+	}
+
+	gr, c, err := a.programReader(name, q)
+	if err != nil {
+		return nil, err
+	}
+	if q.Get("line") != "" {
+		j.ResumeLine, err = strconv.Atoi(q.Get("line"))
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		gr, err = resumeReader(gr, j.ResumeLine, j.safeZ, q)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if j.est != nil {
+			j.startAt = j.est.At(j.ResumeLine - 1)
+		}
+	}
+
+	jm := a.jobManager
+	jm.mx.Lock()
+	if jm.cur != nil {
+		jm.mx.Unlock()
+		c.Close()
+		return nil, errors.New("a job is already running")
+	}
+	j.ID = len(jm.jobs) + 1
+	j.Started = time.Now()
+	jm.jobs = append(jm.jobs, j)
+	jm.cur = j
+	jm.publish(j, true)
+	jm.mx.Unlock()
+
+	go func() {
+		defer c.Close()
+		jr := &jobReader{jm: jm, j: j, gr: gr}
+		_, err := a.m.ReadFrom(jr)
+		if err == nil {
+			err = jr.err
+		}
+
+		jm.mx.Lock()
+		defer jm.mx.Unlock()
+		switch {
+		case j.stopping:
+			j.State = jobStopped
+		case err != nil:
+			log.Printf("ERROR: job %d '%s': %+v", j.ID, j.File, err)
+			j.State = jobFailed
+			j.Error = err.Error()
+		default:
+			j.State = jobDone
+			j.progress(j.Lines)
+			j.Percent = 100
+		}
+		j.ETA = nil
+		jm.cur = nil
+		jm.publish(j, true)
+	}()
+
+	return j, nil
+}
+
+// safeZ will return the machine Z to retract to when stopping or resuming a job,
+// from the "safeZ" parameter or the travel height of the tool change config.
+func (a *api) safeZ(q url.Values) (float64, error) {
+	if q.Get("safeZ") != "" {
+		return strconv.ParseFloat(q.Get("safeZ"), 64)
+	}
+	opt, err := a.readToolChangeConfig()
+	if err != nil {
+		return 0, err
+	}
+	if opt == nil {
+		return 0, errors.New("safeZ is required if a tool change is not configured")
+	}
+	return opt.TravelHeight, nil
+}
+
+// resumeOptions will read resume options from the query.
+func resumeOptions(safeZ float64, q url.Values) (opt gcode.ResumeOptions, err error) {
+	opt = gcode.ResumeOptions{SafeZ: safeZ, SpindleDelay: defaultSpindleDelay}
+	if q.Get("spindleDelay") != "" {
+		opt.SpindleDelay, err = strconv.ParseFloat(q.Get("spindleDelay"), 64)
+		if err != nil {
+			return opt, err
+		}
+	}
+	if q.Get("plungeFeed") != "" {
+		opt.PlungeFeed, err = strconv.ParseFloat(q.Get("plungeFeed"), 64)
+		if err != nil {
+			return opt, err
+		}
+	}
+	return opt, nil
+}
+
+// resumeReader will start gr from line, with a preamble to restore the state of the program.
+func resumeReader(gr gcode.Reader, line int, safeZ float64, q url.Values) (gcode.LineReader, error) {
+	lr, ok := gr.(gcode.LineReader)
+	if !ok {
+		return nil, errors.New("program does not report lines")
+	}
+	opt, err := resumeOptions(safeZ, q)
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := gcode.Resume(lr, line, opt)
+	return r, err
+}
+
+// resumePreview will return the state and preamble to resume a program at a line, without running it.
+func (a *api) resumePreview(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	line, err := strconv.Atoi(q.Get("line"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	safeZ, err := a.safeZ(q)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	opt, err := resumeOptions(safeZ, q)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	gr, c, err := a.programReader(q.Get("file"), q)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer c.Close()
+	lr, ok := gr.(gcode.LineReader)
+	if !ok {
+		http.Error(w, "program does not report lines", 400)
+		return
+	}
+	state, _, err := gcode.ReadState(lr, line)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	pre, err := state.Preamble(opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	res := struct {
+		State    *gcode.ProgramState
+		Preamble []string
+	}{State: state}
+	for _, b := range pre {
+		res.Preamble = append(res.Preamble, b.String())
+	}
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
+}
+
+// controlJob will pause, resume or stop job j.
+func (a *api) controlJob(j *job, action string) error {
+	jm := a.jobManager
+	jm.mx.Lock()
+	defer jm.mx.Unlock()
+	if jm.cur != j {
+		return errors.New("job not running")
+	}
+
+	switch action {
+	case "pause":
+		if j.State != jobRunning {
+			return errors.New("job not running")
+		}
+		err := a.m.Pause()
+		if err != nil {
+			return err
+		}
+		j.State = jobPaused
+		j.pausedSince = time.Now()
+		j.ETA = nil
+	case "resume":
+		if j.State != jobPaused {
+			return errors.New("job not paused")
+		}
+		err := a.m.Resume()
+		if err != nil {
+			return err
+		}
+		j.State = jobRunning
+		j.paused += time.Since(j.pausedSince)
+	case "stop":
+		if j.stopping {
+			return nil
+		}
+		j.stopping = true
+		j.State = jobStopping
+		go func() {
+			err := a.m.Stop(j.safeZ)
+			if err != nil {
+				log.Printf("ERROR: stop job %d: %+v", j.ID, err)
+			}
+		}()
+	default:
+		return os.ErrNotExist
+	}
+	jm.publish(j, true)
+	return nil
+}
+
+// jobs handles the job API:
+//
+//	GET  /api/jobs                 list jobs
+//	POST /api/jobs?file=name       start a program from the data directory, with leveling options as for /api/run
+//	                               and optionally line, spindleDelay and plungeFeed to resume from a line.
+//	                               safeZ (machine Z) defaults to the tool change travel height, and is required without one
+//	GET  /api/jobs/resume?file=&line=  preview the state and preamble used to resume from a line
+//	GET  /api/jobs/{id}            get a job
+//	POST /api/jobs/{id}/pause      feed hold
+//	POST /api/jobs/{id}/resume     continue after pause
+//	POST /api/jobs/{id}/stop       stop, turn off the spindle and retract to safeZ
+//
+// Job updates are sent to /events/job.
+func (a *api) jobs(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/jobs"), "/"), "/")
+	if parts[0] == "" {
+		switch req.Method {
+		case "GET":
+			a.jobManager.mx.Lock()
+			data, err := json.Marshal(append([]*job{}, a.jobManager.jobs...))
+			a.jobManager.mx.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			w.Write(data)
+		case "POST":
+			j, err := a.startJob(req.URL.Query().Get("file"), req.URL.Query())
+			if err != nil {
+				log.Println("ERROR: start job:", err)
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			a.writeJob(w, j)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if parts[0] == "resume" && len(parts) == 1 {
+		if req.Method != "GET" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		a.resumePreview(w, req)
+		return
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		http.NotFound(w, req)
+		return
+	}
+	a.jobManager.mx.Lock()
+	var j *job
+	if id > 0 && id <= len(a.jobManager.jobs) {
+		j = a.jobManager.jobs[id-1]
+	}
+	a.jobManager.mx.Unlock()
+	if j == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	if len(parts) == 1 {
+		if req.Method != "GET" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		a.writeJob(w, j)
+		return
+	}
+
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	err = a.controlJob(j, parts[1])
+	if os.IsNotExist(err) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: %s job %d: %+v", parts[1], id, err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	a.writeJob(w, j)
+}
+
+func (a *api) writeJob(w io.Writer, j *job) {
+	a.jobManager.mx.Lock()
+	data, err := json.Marshal(j)
+	a.jobManager.mx.Unlock()
+	if err != nil {
+		log.Printf("ERROR: marshal json: %+v", err)
+		return
+	}
+	w.Write(data)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/mastercactapus/gcnc/machine"
+	"github.com/mastercactapus/gcnc/meshlevel"
+)
+
+// parsePoint will parse a point in the form "X,Y,Z".
+func parsePoint(s string) (coord.Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return coord.Point{}, errors.New("point must be in the form X,Y,Z")
+	}
+	var v [3]float64
+	for i, p := range parts {
+		var err error
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return coord.Point{}, err
+		}
+	}
+	return coord.Point{X: v[0], Y: v[1], Z: v[2]}, nil
+}
+
+// countReader counts the blocks read.
+type countReader struct {
+	gcode.Reader
+	n int
+}
+
+func (c *countReader) Read() (gcode.Block, error) {
+	b, err := c.Reader.Read()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// levelMain will run the "level" subcommand, leveling a program offline.
+func levelMain(args []string) error {
+	fs := flag.NewFlagSet("level", flag.ExitOnError)
+	meshFile := fs.String("mesh", "", "Mesh file to level with (required).")
+	wcoStr := fs.String("wco", "", "Work coordinate offset as X,Y,Z. Defaults to the WCO of the mesh.")
+	mposStr := fs.String("mpos", "", "Machine position at the start of the program as X,Y,Z. Defaults to the work origin.")
+	zeroStr := fs.String("zero", "", "Machine position where Z was zeroed as X,Y,Z. Defaults to the one recorded with the mesh, or the work origin.")
+	out := fs.String("o", "-", "Output file, or - for stdout.")
+	granularity := fs.Float64("granularity", 1, "Max segment length, in mm.")
+	tolerance := fs.Float64("tolerance", 0, "Max Z error, in mm. If set, moves are only split where needed.")
+	edge := fs.String("edge", "", "How to handle moves outside of the mesh: clamp, extrapolate or fail. Default is to leave them as-is.")
+	model := fs.String("model", "", "Surface model: bilinear, bicubic or plane. Default is a triangle mesh.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gcnc level -mesh <file> [options] [input.nc]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *meshFile == "" {
+		fs.Usage()
+		return errors.New("mesh is required")
+	}
+
+	var wco coord.Point
+	var err error
+	if *wcoStr != "" {
+		wco, err = parsePoint(*wcoStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(*meshFile)
+	if err != nil {
+		return err
+	}
+	mf, err := machine.ReadMeshFile(f, wco)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if *wcoStr == "" {
+		wco = mf.WCO
+	}
+
+	mPos := wco
+	if *mposStr != "" {
+		mPos, err = parsePoint(*mposStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	zero := mf.MachineZero(wco)
+	if *zeroStr != "" {
+		p, err := parsePoint(*zeroStr)
+		if err != nil {
+			return err
+		}
+		zero = &p
+	}
+
+	m, err := meshlevel.ParseModel(*model)
+	if err != nil {
+		return err
+	}
+	edgeMode, err := meshlevel.ParseEdgeMode(*edge)
+	if err != nil {
+		return err
+	}
+	z, err := meshlevel.NewZOffsetter(m, mf.MachinePoints(wco))
+	if err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	src := &countReader{Reader: gcode.NewParser(in)}
+	l := meshlevel.New(meshlevel.Config{
+		ZOffsetter:  z,
+		MPos:        mPos,
+		WCO:         wco,
+		Zero:        zero,
+		Granularity: *granularity,
+		Tolerance:   *tolerance,
+		Edge:        edgeMode,
+		Reader:      src,
+	})
+	dst := &countReader{Reader: l}
+
+	_, err = io.Copy(w, gcode.NewBuffer(dst))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "blocks: %d in, %d out (%d added)\n", src.n, dst.n, dst.n-src.n)
+	fmt.Fprintf(os.Stderr, "max offset: %.4fmm\n", l.MaxOffset())
+	return nil
+}
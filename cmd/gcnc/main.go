@@ -4,6 +4,7 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/mastercactapus/gcnc/machine"
 	"github.com/mastercactapus/gcnc/machine/grbl"
@@ -14,6 +15,14 @@ import (
 func main() {
 	log.SetFlags(log.Lshortfile)
 
+	if len(os.Args) > 1 && os.Args[1] == "level" {
+		err := levelMain(os.Args[2:])
+		if err != nil {
+			log.Fatal("ERROR: level: ", err)
+		}
+		return
+	}
+
 	port := flag.String("port", "/dev/ttyUSB0", "Port path (or name if using SPJS).")
 	spjsURL := flag.String("spjs", "ws://cnc-bridge:8989/ws", "Websocket URL of the SPJS server to use.")
 	controller := flag.String("controller", "grbl", "Name of the controller to use.")
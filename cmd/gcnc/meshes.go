@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/machine"
+)
+
+// defaultMesh is used if no mesh has been selected.
+const defaultMesh = "default"
+
+var meshNameRx = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+func validMeshName(name string) bool {
+	return meshNameRx.MatchString(name)
+}
+
+// selectedMesh will return the name of the currently selected mesh.
+func (a *api) selectedMesh() string {
+	_, name := safePath(a.dataDir, "meshes/selected")
+	data, err := ioutil.ReadFile(name)
+	if err != nil || !validMeshName(strings.TrimSpace(string(data))) {
+		return defaultMesh
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// meshName will return the mesh requested by the "meshName" parameter, or the selected mesh.
+func (a *api) meshName(q url.Values) string {
+	if name := q.Get("meshName"); name != "" {
+		return name
+	}
+	return a.selectedMesh()
+}
+
+func (a *api) meshPath(name string) (bool, string) {
+	if !validMeshName(name) {
+		return false, ""
+	}
+	return safePath(a.dataDir, "meshes/"+name+".json")
+}
+
+// readMesh will read a saved mesh by name.
+//
+// The default mesh falls back to the legacy grid.json file.
+func (a *api) readMesh(name string) (*machine.MeshFile, error) {
+	ok, fullName := a.meshPath(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(fullName)
+	if os.IsNotExist(err) && name == defaultMesh {
+		_, fullName = safePath(a.dataDir, "grid.json")
+		f, err = os.Open(fullName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mf, err := machine.ReadMeshFile(f, a.m.CurrentState().WCO)
+	if err != nil {
+		return nil, err
+	}
+	mf.Name = name
+	return mf, nil
+}
+
+// writeMesh will save a mesh, replacing any with the same name.
+func (a *api) writeMesh(mf *machine.MeshFile) error {
+	ok, fullName := a.meshPath(mf.Name)
+	if !ok {
+		return os.ErrNotExist
+	}
+	mf.Version = machine.MeshFileVersion
+	data, err := json.Marshal(mf)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(fullName), 0755)
+	return ioutil.WriteFile(fullName, data, 0644)
+}
+
+// partialPath will return the file used to store results of an unfinished grid probe.
+func (a *api) partialPath(name string) (bool, string) {
+	if !validMeshName(name) {
+		return false, ""
+	}
+	return safePath(a.dataDir, "meshes/"+name+".partial")
+}
+
+// readPartial will read the progress of an unfinished grid probe, in machine coordinates.
+func (a *api) readPartial(name string) (*machine.GridProgress, error) {
+	ok, fullName := a.partialPath(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(fullName)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return nil, errors.New("partial grid does not record its origin and can not be resumed")
+	}
+	var p machine.GridProgress
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// writePartial will save the progress of a grid probe.
+func (a *api) writePartial(name string, p *machine.GridProgress) error {
+	ok, fullName := a.partialPath(name)
+	if !ok {
+		return os.ErrNotExist
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(fullName), 0755)
+	return ioutil.WriteFile(fullName, data, 0644)
+}
+
+func (a *api) removePartial(name string) {
+	ok, fullName := a.partialPath(name)
+	if !ok {
+		return
+	}
+	err := os.Remove(fullName)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("ERROR: delete '%s': %+v", fullName, err)
+	}
+}
+
+type meshInfo struct {
+	Name     string
+	Time     time.Time
+	Points   int
+	Selected bool
+}
+
+// listMeshes will return all saved meshes, sorted by name.
+func (a *api) listMeshes() ([]meshInfo, error) {
+	_, dir := safePath(a.dataDir, "meshes")
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	selected := a.selectedMesh()
+	res := []meshInfo{}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".json")
+		if f.IsDir() || name == f.Name() || !validMeshName(name) {
+			continue
+		}
+		mf, err := a.readMesh(name)
+		if err != nil {
+			log.Printf("ERROR: read mesh '%s': %+v", name, err)
+			continue
+		}
+		res = append(res, meshInfo{Name: name, Time: mf.Time, Points: len(mf.Points), Selected: name == selected})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// meshes handles the mesh API:
+//
+//	GET    /api/meshes               list saved meshes
+//	GET    /api/meshes/{name}        get a mesh file
+//	DELETE /api/meshes/{name}        delete a mesh
+//	POST   /api/meshes/{name}/select use a mesh by default
+//
+//	POST   /api/meshes/{name}/reference?index=N  probe reference point N at the current position
+//	POST   /api/meshes/{name}/register?index=N   probe the new position of reference point N
+func (a *api) meshes(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/meshes"), "/"), "/")
+	switch {
+	case parts[0] == "" && req.Method == "GET":
+		list, err := a.listMeshes()
+		if err != nil {
+			log.Println("ERROR: list meshes:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		err = json.NewEncoder(w).Encode(list)
+		if err != nil {
+			log.Println("ERROR: encode:", err)
+		}
+		return
+	case parts[0] == "":
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	case !validMeshName(parts[0]) || len(parts) > 2:
+		http.NotFound(w, req)
+		return
+	}
+	name := parts[0]
+
+	if len(parts) == 2 && (parts[1] == "reference" || parts[1] == "register") {
+		a.meshReference(w, req, name, parts[1] == "register")
+		return
+	}
+	if len(parts) == 2 && parts[1] != "select" {
+		http.NotFound(w, req)
+		return
+	}
+
+	if len(parts) == 2 {
+		if req.Method != "POST" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		_, err := a.readMesh(name)
+		if err != nil {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		_, selFile := safePath(a.dataDir, "meshes/selected")
+		os.MkdirAll(filepath.Dir(selFile), 0755)
+		err = ioutil.WriteFile(selFile, []byte(name), 0644)
+		if err != nil {
+			log.Println("ERROR: select mesh:", err)
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		mf, err := a.readMesh(name)
+		if err != nil {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		err = json.NewEncoder(w).Encode(mf)
+		if err != nil {
+			log.Println("ERROR: encode:", err)
+		}
+	case "DELETE":
+		_, fullName := a.meshPath(name)
+		err := os.Remove(fullName)
+		if os.IsNotExist(err) && name == defaultMesh {
+			// same legacy fallback as readMesh
+			_, fullName = safePath(a.dataDir, "grid.json")
+			err = os.Remove(fullName)
+		}
+		if os.IsNotExist(err) {
+			http.Error(w, err.Error(), 404)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: delete mesh '%s': %+v", name, err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// meshReference will Z-probe at the current position and record it as a reference
+// point of a mesh, or as a new measurement of one if register is set.
+func (a *api) meshReference(w http.ResponseWriter, req *http.Request, name string, register bool) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	index, err := strconv.Atoi(req.URL.Query().Get("index"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var opt machine.ProbeOptions
+	err = json.NewDecoder(req.Body).Decode(&opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	// zeroing would change the work offset the point is recorded against
+	opt.ZeroZAxis = false
+
+	mf, err := a.readMesh(name)
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if index < 0 || index >= len(mf.References) {
+		http.Error(w, "invalid reference index", 400)
+		return
+	}
+
+	res, err := a.m.ProbeZ(opt)
+	if err != nil {
+		log.Println("ERROR: probe reference:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !res.Valid {
+		http.Error(w, "probe did not make contact", 500)
+		return
+	}
+	p := res.Sub(a.m.CurrentState().WCO)
+
+	if register {
+		_, err = mf.Register(index, p)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	} else {
+		mf.References[index] = &p
+		mf.Registration = [3]*coord.Point{}
+	}
+
+	err = a.writeMesh(mf)
+	if err != nil {
+		log.Println("ERROR: write mesh:", err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	err = json.NewEncoder(w).Encode(mf)
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
+}
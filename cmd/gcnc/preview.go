@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mastercactapus/gcnc/meshlevel"
+	"github.com/mastercactapus/gcnc/preview"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// readToolpath will interpret a program from the data directory.
+func (a *api) readToolpath(name string, q url.Values) ([]toolpath.Segment, error) {
+	r, c, err := a.programReader(name, q)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	stat := a.m.CurrentState()
+	return toolpath.ReadAll(r, stat.MPos, stat.WCO)
+}
+
+func (a *api) preview(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+
+	opt := preview.Options{
+		View:   preview.View(q.Get("view")),
+		Width:  800,
+		Height: 600,
+	}
+	if opt.View == "" {
+		opt.View = preview.Top
+	}
+	var err error
+	if q.Get("width") != "" {
+		opt.Width, err = strconv.Atoi(q.Get("width"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	if q.Get("height") != "" {
+		opt.Height, err = strconv.Atoi(q.Get("height"))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	segs, err := a.readToolpath(q.Get("file"), q)
+	if err != nil {
+		log.Println("ERROR: read toolpath:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if q.Get("mesh") == "1" {
+		points, _, err := a.readGrid(q)
+		if err != nil {
+			log.Println("ERROR: read mesh:", err)
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		mesh, err := meshlevel.NewMesh(points)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		opt.Mesh = mesh.Triangles()
+	}
+
+	switch q.Get("format") {
+	case "", "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = preview.SVG(w, segs, opt)
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		err = preview.PNG(w, segs, opt)
+	default:
+		http.Error(w, "unknown format", 400)
+		return
+	}
+	if err != nil {
+		log.Println("ERROR: render preview:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/mastercactapus/gcnc/machine"
+)
+
+// probeXYRequest selects an XY probing routine and its options.
+type probeXYRequest struct {
+	machine.ProbeXYOptions
+
+	// Op is one of edge, corner, bore, boss or angle.
+	Op string
+
+	// Axis and Direction select the edge to probe for edge and angle.
+	Axis      string
+	Direction float64
+
+	// DirectionX and DirectionY point toward the corner.
+	DirectionX, DirectionY float64
+
+	// Spacing is the distance between the two probes of an angle measurement.
+	Spacing float64
+}
+
+func (a *api) probeXY(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	var r probeXYRequest
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var axis byte
+	if len(r.Axis) == 1 {
+		axis = r.Axis[0]
+	}
+
+	var res *machine.ProbeXYResult
+	switch r.Op {
+	case "edge":
+		res, err = a.m.ProbeEdge(axis, r.Direction, r.ProbeXYOptions)
+	case "corner":
+		res, err = a.m.ProbeCorner(r.DirectionX, r.DirectionY, r.ProbeXYOptions)
+	case "bore":
+		res, err = a.m.ProbeCenter(false, r.ProbeXYOptions)
+	case "boss":
+		res, err = a.m.ProbeCenter(true, r.ProbeXYOptions)
+	case "angle":
+		res, err = a.m.ProbeAngle(axis, r.Direction, r.Spacing, r.ProbeXYOptions)
+	default:
+		http.Error(w, "unknown op: "+r.Op, 400)
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR: probe %s: %+v", r.Op, err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/mastercactapus/gcnc/raster"
+)
+
+// readImage will decode a PNG or JPEG from the data directory.
+func (a *api) readImage(name string) (image.Image, error) {
+	ok, fullName := safePath(a.dataDir, name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(fullName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// runBlocks will stream the generated program to the machine, or write
+// it to the response if dryRun=1 is set.
+func (a *api) runBlocks(w http.ResponseWriter, req *http.Request, b []gcode.Block) {
+	var err error
+	r := gcode.NewBuffer(&gcode.BlocksReader{Blocks: b})
+	if req.URL.Query().Get("dryRun") == "1" {
+		w.Header().Set("Content-Type", "text/plain")
+		_, err = io.Copy(w, r)
+	} else {
+		_, err = a.m.ReadFrom(r)
+	}
+	if err != nil {
+		log.Printf("ERROR: run %s: %+v", req.URL.Path, err)
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+func (a *api) raster(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	var opt raster.Options
+	err = json.Unmarshal(data, &opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	img, err := a.readImage(req.URL.Query().Get("file"))
+	if err != nil {
+		log.Println("ERROR: read image:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	b, err := raster.Generate(img, opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	a.runBlocks(w, req, b)
+}
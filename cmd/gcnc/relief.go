@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/mastercactapus/gcnc/relief"
+)
+
+func (a *api) relief(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	var opt relief.Options
+	err = json.Unmarshal(data, &opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	img, err := a.readImage(req.URL.Query().Get("file"))
+	if err != nil {
+		log.Println("ERROR: read image:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	b, err := relief.Generate(img, opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	a.runBlocks(w, req, b)
+}
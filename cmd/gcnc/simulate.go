@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/sim"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// simulate will cut a program from the data directory out of a block of stock.
+//
+// Stock and program coordinates are both in work coordinates.
+func (a *api) simulate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return
+	}
+	var opt sim.Options
+	err = json.Unmarshal(data, &opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	r, c, err := a.programReader(q.Get("file"), nil)
+	if err != nil {
+		log.Println("ERROR: open program:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer c.Close()
+
+	stat := a.m.CurrentState()
+	segs, err := toolpath.ReadAll(r, stat.MPos.Sub(stat.WCO), coord.Point{})
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	stock, rep, err := sim.Simulate(segs, opt)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	switch q.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(rep)
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		err = png.Encode(w, stock.Image())
+	default:
+		http.Error(w, "unknown format", 400)
+		return
+	}
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
+}
@@ -5,10 +5,138 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 
+	"github.com/mastercactapus/gcnc/coord"
 	"github.com/mastercactapus/gcnc/machine"
 )
 
+// readReference will read the saved reference tool position, or nil if it was never measured.
+func (a *api) readReference() (*coord.Point, error) {
+	_, name := safePath(a.dataDir, "tool-reference.json")
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p coord.Point
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (a *api) writeReference(p coord.Point) error {
+	_, name := safePath(a.dataDir, "tool-reference.json")
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(name), 0755)
+	err = ioutil.WriteFile(name, data, 0644)
+	if err != nil {
+		return err
+	}
+	a.configureToolChange()
+	return nil
+}
+
+// readToolChangeConfig will read the tool change used for M6 while streaming, or nil if not configured.
+func (a *api) readToolChangeConfig() (*machine.ToolChangeOptions, error) {
+	_, name := safePath(a.dataDir, "toolchange.json")
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var opt machine.ToolChangeOptions
+	err = json.Unmarshal(data, &opt)
+	if err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+// configureToolChange will set up the machine to run tool changes at M6,
+// using the saved configuration and reference tool.
+func (a *api) configureToolChange() {
+	opt, err := a.readToolChangeConfig()
+	if err != nil {
+		log.Println("ERROR: read tool change config:", err)
+		return
+	}
+	if opt != nil && opt.ReferencePos == nil {
+		opt.ReferencePos, err = a.readReference()
+		if err != nil {
+			log.Println("ERROR: read tool reference:", err)
+		}
+	}
+	a.m.SetToolChange(opt)
+}
+
+// toolChangeConfig will get (GET), set (PUT) or remove (DELETE) the tool change
+// run at M6 while streaming programs. Without one, streaming pauses with a prompt.
+func (a *api) toolChangeConfig(w http.ResponseWriter, req *http.Request) {
+	_, name := safePath(a.dataDir, "toolchange.json")
+	switch req.Method {
+	case "GET":
+		opt, err := a.readToolChangeConfig()
+		if err != nil {
+			log.Println("ERROR: read tool change config:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if opt == nil {
+			http.Error(w, "tool change not configured", http.StatusNotFound)
+			return
+		}
+		err = json.NewEncoder(w).Encode(opt)
+		if err != nil {
+			log.Println("ERROR: encode:", err)
+		}
+		return
+	case "PUT":
+		var opt machine.ToolChangeOptions
+		err := json.NewDecoder(req.Body).Decode(&opt)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		// per-change values are set at each M6
+		opt.Tool = 0
+		opt.LastToolPos = nil
+		data, err := json.Marshal(opt)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		os.MkdirAll(filepath.Dir(name), 0755)
+		err = ioutil.WriteFile(name, data, 0644)
+		if err != nil {
+			log.Println("ERROR: write tool change config:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	case "DELETE":
+		err := os.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("ERROR: delete tool change config:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	a.configureToolChange()
+}
+
 func (a *api) toolChange(w http.ResponseWriter, req *http.Request) {
 	if req.Method != "POST" {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
@@ -26,10 +154,82 @@ func (a *api) toolChange(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = a.m.ToolChange(opt)
+	if opt.ReferencePos == nil {
+		opt.ReferencePos, err = a.readReference()
+		if err != nil {
+			log.Println("ERROR: read tool reference:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	opt.Tools = a.toolTable
+
+	res, err := a.m.ToolChange(opt)
 	if err != nil {
 		log.Printf("ERROR: tool-change: %+v", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
+
+	if res.ReferencePos != nil {
+		err = a.writeReference(*res.ReferencePos)
+		if err != nil {
+			log.Println("ERROR: write tool reference:", err)
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
+}
+
+// toolReference will get (GET) or measure (POST) the reference tool position for TLO tool changes.
+func (a *api) toolReference(w http.ResponseWriter, req *http.Request) {
+	var ref *coord.Point
+	switch req.Method {
+	case "GET":
+		var err error
+		ref, err = a.readReference()
+		if err != nil {
+			log.Println("ERROR: read tool reference:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if ref == nil {
+			http.Error(w, "reference tool not measured", http.StatusNotFound)
+			return
+		}
+	case "POST":
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return
+		}
+		var opt machine.ToolChangeOptions
+		err = json.Unmarshal(data, &opt)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		ref, err = a.m.MeasureReference(opt)
+		if err != nil {
+			log.Printf("ERROR: measure tool reference: %+v", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		err = a.writeReference(*ref)
+		if err != nil {
+			log.Println("ERROR: write tool reference:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(ref)
+	if err != nil {
+		log.Println("ERROR: encode:", err)
+	}
 }
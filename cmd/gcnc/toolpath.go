@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// jsonSegment is the compact JSON encoding of a toolpath.Segment.
+type jsonSegment struct {
+	Start  [3]float64      `json:"s"`
+	End    [3]float64      `json:"e"`
+	Motion toolpath.Motion `json:"m"`
+	Feed   float64         `json:"f"`
+	Line   int             `json:"l"`
+}
+
+// binarySegment is the binary encoding of a toolpath.Segment.
+//
+// Each segment is 33 bytes, little-endian.
+type binarySegment struct {
+	Line   uint32
+	Motion uint8
+	Feed   float32
+	Start  [3]float32
+	End    [3]float32
+}
+
+func point64(p coord.Point) [3]float64 { return [3]float64{p.X, p.Y, p.Z} }
+func point32(p coord.Point) [3]float32 {
+	return [3]float32{float32(p.X), float32(p.Y), float32(p.Z)}
+}
+
+// toolpath will stream the interpreted program as segments in machine coordinates.
+//
+// The default format is a JSON array, `format=binary` will return packed
+// little-endian records of: line (uint32), motion (uint8), feed (float32),
+// start XYZ (float32) and end XYZ (float32).
+func (a *api) toolpath(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "binary" {
+		http.Error(w, "unknown format", 400)
+		return
+	}
+
+	r, c, err := a.programReader(q.Get("file"), q)
+	if err != nil {
+		log.Println("ERROR: open program:", err)
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer c.Close()
+
+	stat := a.m.CurrentState()
+	interp := toolpath.NewInterpreter(r, stat.MPos, stat.WCO)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if format == "binary" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		bw.WriteString("[")
+	}
+
+	var n int
+	for {
+		segs, err := interp.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// headers are already sent, so the best we can do is log and truncate
+			log.Printf("ERROR: toolpath '%s': %+v", q.Get("file"), err)
+			return
+		}
+		for _, s := range segs {
+			if format == "binary" {
+				err = binary.Write(bw, binary.LittleEndian, binarySegment{
+					Line:   uint32(s.Line),
+					Motion: uint8(s.Motion),
+					Feed:   float32(s.Feed),
+					Start:  point32(s.Start),
+					End:    point32(s.End),
+				})
+			} else {
+				if n > 0 {
+					bw.WriteString(",")
+				}
+				err = enc.Encode(jsonSegment{
+					Start:  point64(s.Start),
+					End:    point64(s.End),
+					Motion: s.Motion,
+					Feed:   s.Feed,
+					Line:   s.Line,
+				})
+			}
+			if err != nil {
+				log.Println("ERROR: encode:", err)
+				return
+			}
+			n++
+		}
+	}
+
+	if format != "binary" {
+		bw.WriteString("]\n")
+	}
+	err = bw.Flush()
+	if err != nil {
+		log.Println("ERROR: write:", err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mastercactapus/gcnc/machine"
+)
+
+var errToolExists = errors.New("tool already exists")
+
+// toolTable is the tool table stored in the data directory.
+type toolTable struct {
+	mx   sync.Mutex
+	file string
+}
+
+var _ machine.Tools = &toolTable{}
+
+func newToolTable(dir string) *toolTable {
+	_, name := safePath(dir, "tools.json")
+	return &toolTable{file: name}
+}
+
+func (t *toolTable) read() (machine.ToolTable, error) {
+	f, err := os.Open(t.file)
+	if os.IsNotExist(err) {
+		return machine.ToolTable{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return machine.ReadToolTable(f)
+}
+
+// List will return all tools.
+func (t *toolTable) List() (machine.ToolTable, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.read()
+}
+
+// update will apply fn to the tool table and save the result.
+func (t *toolTable) update(fn func(machine.ToolTable) (machine.ToolTable, error)) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	tools, err := t.read()
+	if err != nil {
+		return err
+	}
+	tools, err = fn(tools)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(tools)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(t.file), 0755)
+	return ioutil.WriteFile(t.file, data, 0644)
+}
+
+// Tool will return tool n from the table.
+func (t *toolTable) Tool(n int) (*machine.Tool, bool) {
+	tools, err := t.List()
+	if err != nil {
+		log.Println("ERROR: read tool table:", err)
+		return nil, false
+	}
+	return tools.Tool(n)
+}
+
+// AddUsage will add to the usage time of tool n, if it is in the table.
+func (t *toolTable) AddUsage(n int, d time.Duration) {
+	err := t.update(func(tools machine.ToolTable) (machine.ToolTable, error) {
+		if tool, ok := tools.Tool(n); ok {
+			tool.UsageTime += d.Seconds()
+		}
+		return tools, nil
+	})
+	if err != nil {
+		log.Println("ERROR: update tool usage:", err)
+	}
+}
+
+// RecordLength will save the measured length of tool n, adding it to the table if needed.
+func (t *toolTable) RecordLength(n int, length float64) {
+	err := t.update(func(tools machine.ToolTable) (machine.ToolTable, error) {
+		now := time.Now()
+		tool, ok := tools.Tool(n)
+		if !ok {
+			tools, _ = tools.Set(machine.Tool{Number: n})
+			tool, _ = tools.Tool(n)
+		}
+		tool.Length = &length
+		tool.Measured = &now
+		return tools, nil
+	})
+	if err != nil {
+		log.Println("ERROR: record tool length:", err)
+	}
+}
+
+// tools handles the tool table API:
+//
+//	GET    /api/tools     list tools
+//	POST   /api/tools     add a tool
+//	GET    /api/tools/{n} get a tool
+//	PUT    /api/tools/{n} replace a tool
+//	DELETE /api/tools/{n} delete a tool
+func (a *api) tools(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/tools"), "/")
+	if path == "" {
+		switch req.Method {
+		case "GET":
+			list, err := a.toolTable.List()
+			if err != nil {
+				log.Println("ERROR: read tool table:", err)
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			err = json.NewEncoder(w).Encode(list)
+			if err != nil {
+				log.Println("ERROR: encode:", err)
+			}
+		case "POST":
+			var tool machine.Tool
+			err := json.NewDecoder(req.Body).Decode(&tool)
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+			err = a.toolTable.update(func(tools machine.ToolTable) (machine.ToolTable, error) {
+				if _, ok := tools.Tool(tool.Number); ok {
+					return nil, errToolExists
+				}
+				return tools.Set(tool)
+			})
+			if err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	n, err := strconv.Atoi(path)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		tool, ok := a.toolTable.Tool(n)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		err = json.NewEncoder(w).Encode(tool)
+		if err != nil {
+			log.Println("ERROR: encode:", err)
+		}
+	case "PUT":
+		var tool machine.Tool
+		err = json.NewDecoder(req.Body).Decode(&tool)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		tool.Number = n
+		err = a.toolTable.update(func(tools machine.ToolTable) (machine.ToolTable, error) {
+			return tools.Set(tool)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	case "DELETE":
+		err = a.toolTable.update(func(tools machine.ToolTable) (machine.ToolTable, error) {
+			tools, ok := tools.Delete(n)
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return tools, nil
+		})
+		if os.IsNotExist(err) {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			log.Println("ERROR: delete tool:", err)
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,145 @@
+package coord
+
+import (
+	"errors"
+	"math"
+)
+
+// Transform is a rotation followed by a translation.
+type Transform struct {
+	R [3][3]float64
+	T Point
+}
+
+// Identity is a Transform that does nothing.
+var Identity = Transform{R: [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}}
+
+// Apply will transform p.
+func (t Transform) Apply(p Point) Point {
+	return Point{
+		X: t.R[0][0]*p.X + t.R[0][1]*p.Y + t.R[0][2]*p.Z + t.T.X,
+		Y: t.R[1][0]*p.X + t.R[1][1]*p.Y + t.R[1][2]*p.Z + t.T.Y,
+		Z: t.R[2][0]*p.X + t.R[2][1]*p.Y + t.R[2][2]*p.Z + t.T.Z,
+	}
+}
+
+// centroid will return the average of points.
+func centroid(points []Point) Point {
+	var c Point
+	for _, p := range points {
+		c = c.Add(p)
+	}
+	return c.Div(float64(len(points)))
+}
+
+// FitRigid will find the rotation and translation that best maps each point in from
+// to the same index in to, in the least-squares sense.
+//
+// It uses Horn's closed-form quaternion method.
+func FitRigid(from, to []Point) (Transform, error) {
+	if len(from) != len(to) {
+		return Transform{}, errors.New("point sets must be the same length")
+	}
+	if len(from) < 3 {
+		return Transform{}, errors.New("need at least 3 points to fit a transform")
+	}
+
+	cf, ct := centroid(from), centroid(to)
+
+	var area float64
+	var s [3][3]float64
+	for i := range from {
+		a := from[i].Sub(cf)
+		b := to[i].Sub(ct)
+		av := [3]float64{a.X, a.Y, a.Z}
+		bv := [3]float64{b.X, b.Y, b.Z}
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				s[r][c] += av[r] * bv[c]
+			}
+		}
+		if i > 0 {
+			n := from[i].Sub(from[0]).Cross(from[(i+1)%len(from)].Sub(from[0]))
+			area = math.Max(area, math.Sqrt(n.Dot(n)))
+		}
+	}
+	if area < Epsilon {
+		return Transform{}, errors.New("points are collinear")
+	}
+
+	n := [4][4]float64{
+		{s[0][0] + s[1][1] + s[2][2], s[1][2] - s[2][1], s[2][0] - s[0][2], s[0][1] - s[1][0]},
+		{s[1][2] - s[2][1], s[0][0] - s[1][1] - s[2][2], s[0][1] + s[1][0], s[2][0] + s[0][2]},
+		{s[2][0] - s[0][2], s[0][1] + s[1][0], -s[0][0] + s[1][1] - s[2][2], s[1][2] + s[2][1]},
+		{s[0][1] - s[1][0], s[2][0] + s[0][2], s[1][2] + s[2][1], -s[0][0] - s[1][1] + s[2][2]},
+	}
+	vals, vecs := jacobi(n)
+	best := 0
+	for i := range vals {
+		if vals[i] > vals[best] {
+			best = i
+		}
+	}
+	w, x, y, z := vecs[0][best], vecs[1][best], vecs[2][best], vecs[3][best]
+
+	t := Transform{R: [3][3]float64{
+		{w*w + x*x - y*y - z*z, 2 * (x*y - w*z), 2 * (x*z + w*y)},
+		{2 * (x*y + w*z), w*w - x*x + y*y - z*z, 2 * (y*z - w*x)},
+		{2 * (x*z - w*y), 2 * (y*z + w*x), w*w - x*x - y*y + z*z},
+	}}
+	t.T = ct.Sub(t.Apply(cf))
+	return t, nil
+}
+
+// jacobi will return the eigenvalues and eigenvectors (as columns) of a symmetric matrix.
+func jacobi(a [4][4]float64) ([4]float64, [4][4]float64) {
+	var v [4][4]float64
+	for i := range v {
+		v[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 50; sweep++ {
+		var off float64
+		for p := 0; p < 4; p++ {
+			for q := p + 1; q < 4; q++ {
+				off += a[p][q] * a[p][q]
+			}
+		}
+		if off < 1e-30 {
+			break
+		}
+
+		for p := 0; p < 4; p++ {
+			for q := p + 1; q < 4; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for k := 0; k < 4; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = c*akp - s*akq
+					a[k][q] = s*akp + c*akq
+				}
+				for k := 0; k < 4; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = c*apk - s*aqk
+					a[q][k] = s*apk + c*aqk
+				}
+				for k := 0; k < 4; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	return [4]float64{a[0][0], a[1][1], a[2][2], a[3][3]}, v
+}
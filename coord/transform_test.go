@@ -0,0 +1,43 @@
+package coord
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitRigid(t *testing.T) {
+	from := []Point{{X: 0, Y: 0, Z: 0}, {X: 100, Y: 0, Z: 0.1}, {X: 0, Y: 50, Z: -0.2}}
+
+	// rotate 2 degrees about Z and shift
+	th := 2 * math.Pi / 180
+	var to []Point
+	for _, p := range from {
+		to = append(to, Point{
+			X: p.X*math.Cos(th) - p.Y*math.Sin(th) + 3,
+			Y: p.X*math.Sin(th) + p.Y*math.Cos(th) - 1.5,
+			Z: p.Z + 0.25,
+		})
+	}
+
+	tr, err := FitRigid(from, to)
+	assert.NoError(t, err)
+	for i, p := range from {
+		res := tr.Apply(p)
+		assert.InDelta(t, to[i].X, res.X, 0.000001)
+		assert.InDelta(t, to[i].Y, res.Y, 0.000001)
+		assert.InDelta(t, to[i].Z, res.Z, 0.000001)
+	}
+
+	tr, err = FitRigid(from, from)
+	assert.NoError(t, err)
+	for r := 0; r < 3; r++ {
+		for c := 0; c < 3; c++ {
+			assert.InDelta(t, Identity.R[r][c], tr.R[r][c], 0.000001)
+		}
+	}
+
+	_, err = FitRigid([]Point{{X: 0}, {X: 1}, {X: 2}}, from)
+	assert.Error(t, err)
+}
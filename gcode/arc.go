@@ -0,0 +1,86 @@
+package gcode
+
+import (
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// ArcTolerance is the default max distance between an arc and the chords used to approximate it.
+const ArcTolerance = 0.002
+
+// Arc describes a circular (or helical) move.
+type Arc struct {
+	Start, End, Center coord.Point
+	Clockwise          bool
+
+	// Plane is the active plane selection (17, 18 or 19).
+	Plane float64
+}
+
+// axes will return the two in-plane components and the linear component of p.
+func (a Arc) axes(p coord.Point) (float64, float64, float64) {
+	switch a.Plane {
+	case 18:
+		return p.Z, p.X, p.Y
+	case 19:
+		return p.Y, p.Z, p.X
+	}
+	return p.X, p.Y, p.Z
+}
+func (a Arc) point(u, v, w float64) coord.Point {
+	switch a.Plane {
+	case 18:
+		return coord.Point{X: v, Y: w, Z: u}
+	case 19:
+		return coord.Point{X: w, Y: u, Z: v}
+	}
+	return coord.Point{X: u, Y: v, Z: w}
+}
+
+// Angle returns the signed angle swept by the arc, in radians.
+func (a Arc) Angle() float64 {
+	su, sv, _ := a.axes(a.Start)
+	eu, ev, _ := a.axes(a.End)
+	cu, cv, _ := a.axes(a.Center)
+
+	start := math.Atan2(sv-cv, su-cu)
+	end := math.Atan2(ev-cv, eu-cu)
+	angle := end - start
+	if a.Clockwise && angle >= 0 {
+		angle -= 2 * math.Pi
+	} else if !a.Clockwise && angle <= 0 {
+		angle += 2 * math.Pi
+	}
+	return angle
+}
+
+// Points will return points along the arc, excluding the start and including the end,
+// such that no chord between them is farther than tolerance from the arc.
+func (a Arc) Points(tolerance float64) []coord.Point {
+	su, sv, sw := a.axes(a.Start)
+	_, _, ew := a.axes(a.End)
+	cu, cv, _ := a.axes(a.Center)
+
+	radius := math.Hypot(su-cu, sv-cv)
+	angle := a.Angle()
+
+	n := 1
+	if radius > tolerance {
+		step := 2 * math.Acos(1-tolerance/radius)
+		n = int(math.Ceil(math.Abs(angle) / step))
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	start := math.Atan2(sv-cv, su-cu)
+	res := make([]coord.Point, n)
+	for i := 1; i < n; i++ {
+		t := float64(i) / float64(n)
+		th := start + angle*t
+		res[i-1] = a.point(cu+radius*math.Cos(th), cv+radius*math.Sin(th), sw+(ew-sw)*t)
+	}
+	res[n-1] = a.End
+	return res
+}
@@ -0,0 +1,54 @@
+package gcode
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVM_Arc(t *testing.T) {
+	vm := NewVM()
+	err := vm.Run(MustParse("G2 X10 Y0 I5 J0")[0])
+	assert.NoError(t, err)
+
+	ok, arc := vm.Arc()
+	assert.True(t, ok)
+	assert.Equal(t, coord.Point{X: 5}, arc.Center)
+	assert.True(t, arc.Clockwise)
+
+	pts := arc.Points(0.01)
+	assert.Equal(t, coord.Point{X: 10}, pts[len(pts)-1])
+	for _, p := range pts {
+		// clockwise from the left goes over the top
+		assert.InDelta(t, 5, p.DistanceXY(5, 0), 0.0001)
+		assert.True(t, p.Y >= 0)
+	}
+
+	// same arc, radius format
+	vm = NewVM()
+	err = vm.Run(MustParse("G2 X10 Y0 R5")[0])
+	assert.NoError(t, err)
+	_, arc = vm.Arc()
+	assert.InDelta(t, 5, arc.Center.X, 0.0001)
+	assert.InDelta(t, 0, arc.Center.Y, 0.0001)
+
+	// modal words without motion are not an arc
+	err = vm.Run(MustParse("S1000")[0])
+	assert.NoError(t, err)
+	ok, _ = vm.Arc()
+	assert.False(t, ok)
+}
+
+func TestArc_Points(t *testing.T) {
+	// full circle
+	arc := Arc{Center: coord.Point{X: 1}, Plane: 17}
+	pts := arc.Points(0.001)
+	var maxX float64
+	for _, p := range pts {
+		maxX = math.Max(maxX, p.X)
+	}
+	assert.InDelta(t, 2, maxX, 0.001)
+	assert.Equal(t, coord.Point{}, pts[len(pts)-1])
+}
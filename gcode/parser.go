@@ -9,7 +9,10 @@ import (
 	"strings"
 )
 
-type Parser struct{ br *bufio.Reader }
+type Parser struct {
+	br   *bufio.Reader
+	line int
+}
 
 func NewParser(r io.Reader) *Parser {
 	if br, ok := r.(*bufio.Reader); ok {
@@ -24,9 +27,15 @@ var (
 	rxSplit = regexp.MustCompile(`[A-Z][0-9.\-]+`)
 )
 
+// Line returns the source line number of the last Block read.
+func (p *Parser) Line() int { return p.line }
+
 func (p *Parser) Read() (ln Block, err error) {
 	for {
 		s, err := p.br.ReadString('\n')
+		if s != "" {
+			p.line++
+		}
 		if err == io.EOF && s != "" {
 			err = nil
 		}
@@ -6,6 +6,13 @@ type Reader interface {
 	Read() (Block, error)
 }
 
+// A LineReader is a Reader that can report where the last Block came from
+// in the original program.
+type LineReader interface {
+	Reader
+	Line() int
+}
+
 type BlocksReader struct {
 	Blocks []Block
 	n      int
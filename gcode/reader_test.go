@@ -2,6 +2,7 @@ package gcode
 
 import (
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +30,15 @@ func TestBlocksReader(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 	assert.Nil(t, b)
 }
+
+func TestParser_Line(t *testing.T) {
+	p := NewParser(strings.NewReader("G0 X1\n\n; comment\nG1 X2\n"))
+
+	_, err := p.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.Line())
+
+	_, err = p.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, p.Line())
+}
@@ -0,0 +1,276 @@
+package gcode
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// ProgramState is the modal state of a program before a given line.
+type ProgramState struct {
+	// Line is the first line to run.
+	Line int
+
+	Units, Plane, Distance, ArcDistance, FeedMode, WCS, Motion float64
+
+	// Feed is the feed rate in mm/min.
+	Feed float64
+
+	// Speed is the spindle speed, and Spindle the direction (M3 or M4) or off (M5).
+	Speed, Spindle float64
+
+	Mist, Flood bool
+
+	// Tool is the last tool selected with a T word.
+	Tool int
+
+	// Pos is the work position in mm.
+	Pos coord.Point
+}
+
+// ResumeOptions configure the preamble used to resume a program.
+type ResumeOptions struct {
+	// SafeZ is the machine Z height to travel at.
+	SafeZ float64
+
+	// SpindleDelay is how long, in seconds, to wait for the spindle to start before plunging.
+	SpindleDelay float64
+
+	// PlungeFeed is the feed rate, in mm/min, to plunge to the resume point.
+	// Defaults to the program's feed rate.
+	PlungeFeed float64
+}
+
+// ReadState will read r up to line, returning the program state before it
+// and the first block on or after it.
+func ReadState(r LineReader, line int) (*ProgramState, Block, error) {
+	vm := NewVM()
+	s := &ProgramState{
+		Units:       21,
+		Plane:       17,
+		Distance:    90,
+		ArcDistance: 91.1,
+		FeedMode:    94,
+		WCS:         54,
+		Spindle:     5,
+	}
+	// axes not yet moved to in work coordinates (or last moved in machine coordinates)
+	var unknown [256]bool
+	unknown['X'], unknown['Y'], unknown['Z'] = true, true, true
+
+	for {
+		b, err := r.Read()
+		if err == io.EOF {
+			return nil, nil, errors.New("program ends before line " + strconv.Itoa(line))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if r.Line() >= line {
+			if unknown['X'] || unknown['Y'] || unknown['Z'] {
+				return nil, nil, errors.New("position unknown before line " + strconv.Itoa(r.Line()) + ", resume from a later line")
+			}
+			s.Line = r.Line()
+			s.Feed = vm.Feed()
+			s.Pos = vm.WPos()
+			return s, b, nil
+		}
+
+		var machine, home bool
+		run := make(Block, 0, len(b))
+		for _, w := range b {
+			switch {
+			case w.W == 'G' && w.Arg == 53:
+				machine = true
+			case w.W == 'G' && (w.Arg == 28 || w.Arg == 30):
+				machine, home = true, true
+			case w.W == 'G' && (w.Arg == 92 || w.Arg == 92.1):
+				return nil, nil, errors.New("cannot resume a program using G92 offsets")
+			case w.W == 'T':
+				s.Tool = int(w.Arg)
+			case w.W == 'S':
+				s.Speed = w.Arg
+			case w.W == 'M' && w.Arg == 7:
+				s.Mist = true
+			case w.W == 'M' && w.Arg == 8:
+				s.Flood = true
+			case w.W == 'M' && w.Arg == 9:
+				s.Mist, s.Flood = false, false
+			}
+			switch w.ModalGroup() {
+			case ModalGroupUnits:
+				s.Units = w.Arg
+			case ModalGroupPlaneSelection:
+				s.Plane = w.Arg
+			case ModalGroupDistanceMode:
+				s.Distance = w.Arg
+			case ModalGroupArcDistanceMode:
+				s.ArcDistance = w.Arg
+			case ModalGroupFeedRateMode:
+				s.FeedMode = w.Arg
+			case ModalGroupCoordinateSystem:
+				s.WCS = w.Arg
+			case ModalGroupMotion:
+				s.Motion = w.Arg
+			case ModalGroupSpindle:
+				s.Spindle = w.Arg
+			}
+			if !isSupported(w) {
+				continue
+			}
+			if machine && (w.IsAxis() || w.W == 'I' || w.W == 'J' || w.W == 'K' || w.W == 'R') {
+				// only the modal state of machine coordinate moves is tracked
+				if w.IsAxis() {
+					unknown[w.W] = true
+				}
+				continue
+			}
+			run = append(run, w)
+		}
+		if home {
+			unknown['X'], unknown['Y'], unknown['Z'] = true, true, true
+		}
+
+		err = vm.Run(run)
+		if err != nil {
+			return nil, nil, err
+		}
+		if machine || vm.RelativeMotion() {
+			continue
+		}
+		for _, w := range run {
+			if w.IsAxis() {
+				unknown[w.W] = false
+			}
+		}
+	}
+}
+
+// Preamble will return blocks to restore the state and move to the resume position:
+// up to safe Z, over to the resume XY with the spindle and coolant started, then a plunge at feed.
+func (s *ProgramState) Preamble(opt ResumeOptions) ([]Block, error) {
+	plunge := opt.PlungeFeed
+	if plunge == 0 && s.FeedMode == 94 {
+		plunge = s.Feed
+	}
+	if plunge <= 0 {
+		return nil, errors.New("no feed rate to plunge at")
+	}
+
+	b := []Block{
+		{{W: 'G', Arg: 21}, {W: 'G', Arg: 90}, {W: 'G', Arg: 94}, {W: 'G', Arg: s.Plane}, {W: 'G', Arg: s.WCS}},
+	}
+	if s.Tool > 0 {
+		b = append(b, Block{{W: 'T', Arg: float64(s.Tool)}})
+	}
+	b = append(b, Block{{W: 'G', Arg: 53}, {W: 'G', Arg: 0}, {W: 'Z', Arg: opt.SafeZ}})
+	if s.Spindle == 3 || s.Spindle == 4 {
+		b = append(b, Block{{W: 'M', Arg: s.Spindle}, {W: 'S', Arg: s.Speed}})
+		if opt.SpindleDelay > 0 {
+			b = append(b, Block{{W: 'G', Arg: 4}, {W: 'P', Arg: opt.SpindleDelay}})
+		}
+	}
+	if s.Mist {
+		b = append(b, Block{{W: 'M', Arg: 7}})
+	}
+	if s.Flood {
+		b = append(b, Block{{W: 'M', Arg: 8}})
+	}
+	b = append(b,
+		Block{{W: 'G', Arg: 0}, {W: 'X', Arg: s.Pos.X}, {W: 'Y', Arg: s.Pos.Y}},
+		Block{{W: 'G', Arg: 1}, {W: 'Z', Arg: s.Pos.Z}, {W: 'F', Arg: plunge}},
+	)
+
+	unit := 1.0
+	if s.Units == 20 {
+		unit = 25.4
+	}
+	modes := Block{
+		{W: 'G', Arg: s.Units},
+		{W: 'G', Arg: s.Distance},
+		{W: 'G', Arg: s.ArcDistance},
+		{W: 'G', Arg: s.FeedMode},
+	}
+	// arcs need axis words, they are restored with the first move
+	if s.Motion == 0 || s.Motion == 1 {
+		modes = append(modes, Word{W: 'G', Arg: s.Motion})
+	}
+	if s.FeedMode == 94 && s.Feed > 0 {
+		modes = append(modes, Word{W: 'F', Arg: s.Feed / unit})
+	}
+	return append(b, modes), nil
+}
+
+// resumeReader will return a preamble, then a program from the resume line.
+type resumeReader struct {
+	r        LineReader
+	preamble []Block
+	first    Block
+	motion   float64
+	line     int
+
+	// needMotion is set until a move has been sent with an explicit motion mode.
+	needMotion bool
+}
+
+// Resume will start r at line, with a preamble to restore the state of the program.
+func Resume(r LineReader, line int, opt ResumeOptions) (LineReader, *ProgramState, error) {
+	s, first, err := ReadState(r, line)
+	if err != nil {
+		return nil, nil, err
+	}
+	pre, err := s.Preamble(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &resumeReader{
+		r:          r,
+		preamble:   pre,
+		first:      first,
+		motion:     s.Motion,
+		line:       s.Line,
+		needMotion: true,
+	}, s, nil
+}
+
+func (rr *resumeReader) Line() int { return rr.line }
+
+func (rr *resumeReader) Read() (Block, error) {
+	if len(rr.preamble) > 0 {
+		b := rr.preamble[0]
+		rr.preamble = rr.preamble[1:]
+		return b, nil
+	}
+
+	var b Block
+	if rr.first != nil {
+		b = rr.first
+		rr.first = nil
+	} else {
+		var err error
+		b, err = rr.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		rr.line = rr.r.Line()
+	}
+	if !rr.needMotion {
+		return b, nil
+	}
+
+	var hasAxis bool
+	for _, w := range b {
+		if w.ModalGroup() == ModalGroupMotion {
+			rr.needMotion = false
+			return b, nil
+		}
+		hasAxis = hasAxis || w.IsAxis()
+	}
+	if !hasAxis {
+		return b, nil
+	}
+	rr.needMotion = false
+	return append(Block{{W: 'G', Arg: rr.motion}}, b...), nil
+}
@@ -0,0 +1,95 @@
+package gcode
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+const resumeProgram = `G20 G90 G55
+T2 M6
+S12000 M3
+M8
+G0 X1 Y2
+G0 Z0.1
+G1 Z-0.05 F10
+G2 X2 Y2 I0.5 J0
+X3 Y2 I0.5 J0
+G53 G0 Z0
+G0 X4 Y4
+`
+
+func TestReadState(t *testing.T) {
+	s, first, err := ReadState(NewParser(strings.NewReader(resumeProgram)), 9)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 9, s.Line)
+	assert.Equal(t, 20.0, s.Units)
+	assert.Equal(t, 55.0, s.WCS)
+	assert.Equal(t, 2.0, s.Motion)
+	assert.Equal(t, 3.0, s.Spindle)
+	assert.Equal(t, 12000.0, s.Speed)
+	assert.True(t, s.Flood)
+	assert.False(t, s.Mist)
+	assert.Equal(t, 2, s.Tool)
+	assert.InDelta(t, 254.0, s.Feed, 1e-9)
+	assert.InDelta(t, 2*25.4, s.Pos.X, 1e-9)
+	assert.InDelta(t, 2*25.4, s.Pos.Y, 1e-9)
+	assert.InDelta(t, -0.05*25.4, s.Pos.Z, 1e-9)
+	assert.Equal(t, Block{{W: 'X', Arg: 3}, {W: 'Y', Arg: 2}, {W: 'I', Arg: 0.5}, {W: 'J', Arg: 0}}, first)
+
+	// Z was last set in machine coordinates
+	_, _, err = ReadState(NewParser(strings.NewReader(resumeProgram)), 11)
+	assert.Error(t, err)
+
+	// nothing has moved yet
+	_, _, err = ReadState(NewParser(strings.NewReader(resumeProgram)), 3)
+	assert.Error(t, err)
+
+	_, _, err = ReadState(NewParser(strings.NewReader(resumeProgram)), 20)
+	assert.Error(t, err)
+}
+
+func TestResume(t *testing.T) {
+	r, _, err := Resume(NewParser(strings.NewReader(resumeProgram)), 9, ResumeOptions{SafeZ: -1, SpindleDelay: 2})
+	assert.NoError(t, err)
+
+	var lines []string
+	for {
+		b, err := r.Read()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		lines = append(lines, b.String())
+	}
+
+	assert.Equal(t, []string{
+		"G21G90G94G17G55",
+		"T2",
+		"G53G0Z-1",
+		"M3S12000",
+		"G4P2",
+		"M8",
+		"G0X50.8Y50.8",
+		"G1Z-1.27F254",
+		"G20G90G91.1G94F10",
+		"G2X3Y2I0.5J0",
+		"G53G0Z0",
+		"G0X4Y4",
+	}, lines)
+	assert.Equal(t, 11, r.Line())
+}
+
+func TestProgramState_Preamble(t *testing.T) {
+	s := &ProgramState{Units: 21, Plane: 17, Distance: 90, ArcDistance: 91.1, FeedMode: 94, WCS: 54, Spindle: 5, Motion: 1, Pos: coord.Point{X: 1, Y: 2, Z: -3}}
+	_, err := s.Preamble(ResumeOptions{})
+	assert.Error(t, err, "no feed rate")
+
+	b, err := s.Preamble(ResumeOptions{PlungeFeed: 100})
+	assert.NoError(t, err)
+	assert.Equal(t, "G21G90G91.1G94G1", b[len(b)-1].String())
+}
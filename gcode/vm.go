@@ -2,6 +2,7 @@ package gcode
 
 import (
 	"errors"
+	"math"
 
 	"github.com/mastercactapus/gcnc/coord"
 )
@@ -14,6 +15,9 @@ type VM struct {
 	modal [256]float64
 
 	feed float64
+
+	arc    Arc
+	hasArc bool
 }
 
 // NewVM constructs a new VM with default state.
@@ -40,6 +44,18 @@ func NewVM() *VM {
 func (vm VM) Inches() bool         { return vm.modal[ModalGroupUnits] == 20 }
 func (vm VM) RelativeMotion() bool { return vm.modal[ModalGroupDistanceMode] == 91 }
 
+// Motion returns the active motion mode (e.g. 0 for G0, 1 for G1).
+func (vm VM) Motion() float64 { return vm.modal[ModalGroupMotion] }
+
+// Feed returns the active feed rate in mm/min.
+func (vm VM) Feed() float64 { return vm.feed }
+
+// Plane returns the active plane selection (17, 18 or 19).
+func (vm VM) Plane() float64 { return vm.modal[ModalGroupPlaneSelection] }
+
+// Arc returns the arc for the last block run, if it was an arc move.
+func (vm VM) Arc() (bool, Arc) { return vm.hasArc, vm.arc }
+
 func (vm VM) WPos() coord.Point {
 	return vm.pos.Sub(vm.wco)
 }
@@ -63,14 +79,16 @@ func isSupported(g Word) bool {
 
 	if g.W == 'G' {
 		switch g.Arg {
-		case 0, 1, 91, 90, 20, 21, 94:
+		case 0, 1, 2, 3, 17, 18, 19, 91, 90, 90.1, 91.1, 20, 21, 53, 94:
 			return true
 		}
-	} else if g.W == 'F' {
+	} else if g.W == 'F' || g.W == 'S' || g.W == 'T' {
+		return true
+	} else if g.W == 'I' || g.W == 'J' || g.W == 'K' || g.W == 'R' {
 		return true
 	} else if g.W == 'M' {
 		switch g.Arg {
-		case 3, 5:
+		case 0, 1, 2, 30, 3, 4, 5, 6, 7, 8, 9:
 			return true
 		}
 	}
@@ -94,6 +112,7 @@ func applyBlock(p coord.Point, b Block, mul float64) coord.Point {
 }
 
 func (vm *VM) Run(b Block) error {
+	vm.hasArc = false
 	err := b.Validate()
 	if err != nil {
 		return err
@@ -112,23 +131,108 @@ func (vm *VM) Run(b Block) error {
 		}
 	}
 
+	mul := 1.0
+	if vm.Inches() {
+		mul = 25.4
+	}
+	if ok, f := b.Arg('F'); ok {
+		vm.feed = f * mul
+	}
+
 	args := b.Args()
 	if len(args) == 0 {
 		return nil
 	}
 
-	mul := 1.0
-	if vm.Inches() {
-		mul = 2.54
-	}
 	// apply motion
-	if vm.RelativeMotion() {
+	start := vm.pos
+	if machineCoords {
+		// like grbl, G53 ignores the distance mode
+		vm.pos = applyBlock(vm.pos, args, mul)
+	} else if vm.RelativeMotion() {
 		vm.pos = vm.pos.Add(applyBlock(coord.Point{}, args, mul))
-	} else if machineCoords {
-		vm.pos = applyBlock(vm.pos, args, 1)
 	} else {
 		vm.pos = applyBlock(vm.WPos(), args, mul).Add(vm.wco)
 	}
 
+	if vm.Motion() != 2 && vm.Motion() != 3 {
+		return nil
+	}
+	for _, g := range args {
+		switch g.W {
+		case 'X', 'Y', 'Z', 'I', 'J', 'K', 'R':
+			return vm.runArc(start, args, mul)
+		}
+	}
+
+	return nil
+}
+
+// runArc will calculate the arc from start to the current position.
+func (vm *VM) runArc(start coord.Point, args Block, mul float64) error {
+	vm.arc = Arc{
+		Start:     start,
+		End:       vm.pos,
+		Clockwise: vm.Motion() == 2,
+		Plane:     vm.Plane(),
+	}
+
+	su, sv, _ := vm.arc.axes(start)
+	eu, ev, _ := vm.arc.axes(vm.pos)
+
+	if ok, r := args.Arg('R'); ok {
+		// radius format, same as grbl
+		r *= mul
+		x, y := eu-su, ev-sv
+		if x == 0 && y == 0 {
+			return errors.New("radius arc with no motion")
+		}
+		h := 4*r*r - x*x - y*y
+		if h < 0 {
+			return errors.New("arc radius too small for end point")
+		}
+		h = -math.Sqrt(h) / math.Hypot(x, y)
+		if !vm.arc.Clockwise {
+			h = -h
+		}
+		if r < 0 {
+			h = -h
+		}
+		_, _, w := vm.arc.axes(start)
+		vm.arc.Center = vm.arc.point(su+(x-y*h)/2, sv+(y+x*h)/2, w)
+		vm.hasArc = true
+		return nil
+	}
+
+	var offset coord.Point
+	var hasOffset bool
+	for _, g := range args {
+		switch g.W {
+		case 'I':
+			offset.X = g.Arg * mul
+		case 'J':
+			offset.Y = g.Arg * mul
+		case 'K':
+			offset.Z = g.Arg * mul
+		default:
+			continue
+		}
+		hasOffset = true
+	}
+	if !hasOffset {
+		return errors.New("arc without radius or center offset")
+	}
+
+	if vm.modal[ModalGroupArcDistanceMode] == 90.1 {
+		vm.arc.Center = offset.Add(vm.wco)
+	} else {
+		vm.arc.Center = start.Add(offset)
+	}
+	// center is only meaningful in the plane
+	cu, cv, _ := vm.arc.axes(vm.arc.Center)
+	_, _, w := vm.arc.axes(start)
+	vm.arc.Center = vm.arc.point(cu, cv, w)
+	vm.hasArc = true
+
 	return nil
 }
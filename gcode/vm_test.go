@@ -0,0 +1,69 @@
+package gcode
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVM_Run(t *testing.T) {
+	run := func(vm *VM, s string) {
+		t.Helper()
+		for _, b := range MustParse(s) {
+			assert.NoError(t, vm.Run(b))
+		}
+	}
+
+	t.Run("inches", func(t *testing.T) {
+		vm := NewVM()
+		run(vm, "G20 G1 X1 Y-2 Z0.5 F10")
+		assert.True(t, vm.Inches())
+		assert.Equal(t, coord.Point{X: 25.4, Y: -50.8, Z: 12.7}, vm.WPos())
+		assert.Equal(t, 254.0, vm.Feed())
+
+		run(vm, "G91 X1")
+		assert.InDelta(t, 50.8, vm.WPos().X, 1e-9)
+
+		run(vm, "G21 G90 X1")
+		assert.Equal(t, 1.0, vm.WPos().X)
+	})
+
+	t.Run("feed without motion", func(t *testing.T) {
+		vm := NewVM()
+		run(vm, "F100")
+		assert.Equal(t, 100.0, vm.Feed())
+	})
+
+	t.Run("machine coordinates", func(t *testing.T) {
+		vm := NewVM()
+		vm.SetWCO(coord.Point{X: 10, Y: 20, Z: -5})
+		run(vm, "G0 X1 Y1 Z1")
+		run(vm, "G53 G0 Z-1")
+		assert.Equal(t, coord.Point{X: 11, Y: 21, Z: -1}, vm.MPos())
+		assert.Equal(t, 0.0, vm.Motion())
+
+		// distance mode is ignored
+		run(vm, "G91 G53 Z-5")
+		assert.Equal(t, coord.Point{X: 11, Y: 21, Z: -5}, vm.MPos())
+
+		// units are not
+		run(vm, "G20 G90 G53 Z-1")
+		assert.Equal(t, coord.Point{X: 11, Y: 21, Z: -25.4}, vm.MPos())
+	})
+
+	t.Run("spindle", func(t *testing.T) {
+		vm := NewVM()
+		run(vm, "M4 S12000")
+		assert.Equal(t, 4.0, vm.modal[ModalGroupSpindle])
+		run(vm, "M3")
+		assert.Equal(t, 3.0, vm.modal[ModalGroupSpindle])
+		run(vm, "M5")
+		assert.Equal(t, 5.0, vm.modal[ModalGroupSpindle])
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		vm := NewVM()
+		assert.Error(t, vm.Run(MustParse("G92 X0")[0]))
+	})
+}
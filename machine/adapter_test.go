@@ -0,0 +1,201 @@
+package machine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+)
+
+// fakeAdapter simulates a controller probing a surface, executing each line as it is sent.
+type fakeAdapter struct {
+	mx sync.Mutex
+
+	pos, wco coord.Point
+	relative bool
+	alarm    bool
+	status   string
+
+	// surface will return the machine Z of the surface at x,y.
+	surface func(x, y float64) float64
+
+	probes []ProbeResult
+	lines  []string
+	bytes  []byte
+
+	// resume is sent cycle start while stopped at an M0
+	resume chan struct{}
+}
+
+func newFakeAdapter(pos, wco coord.Point, surface func(x, y float64) float64) *fakeAdapter {
+	return &fakeAdapter{pos: pos, wco: wco, surface: surface, status: "Idle", resume: make(chan struct{}, 1)}
+}
+
+func (f *fakeAdapter) Probes() []ProbeResult {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]ProbeResult(nil), f.probes...)
+}
+func (f *fakeAdapter) ResetProbes() {
+	f.mx.Lock()
+	f.probes = nil
+	f.mx.Unlock()
+}
+func (f *fakeAdapter) State() chan State { return nil }
+func (f *fakeAdapter) CurrentState() State {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	s := State{Status: f.status, MPos: f.pos, WCO: f.wco}
+	if f.alarm {
+		s.Status = "Alarm"
+	}
+	return s
+}
+func (f *fakeAdapter) WriteByte(b byte) error {
+	f.mx.Lock()
+	f.bytes = append(f.bytes, b)
+	if b == '~' && f.status == "Hold:0" {
+		select {
+		case f.resume <- struct{}{}:
+		default:
+		}
+	}
+	f.mx.Unlock()
+	return nil
+}
+func (f *fakeAdapter) Write(p []byte) (int, error) {
+	n, err := f.ReadFrom(bytes.NewReader(p))
+	return int(n), err
+}
+func (f *fakeAdapter) ReadFrom(r io.Reader) (int64, error) {
+	s := bufio.NewScanner(r)
+	var n int64
+	var err error
+	for s.Scan() {
+		n += int64(len(s.Bytes())) + 1
+		lErr := f.run(strings.TrimSpace(s.Text()))
+		if err == nil {
+			err = lErr
+		}
+	}
+	// like the Grbl adapters, read errors end streaming without being returned
+	return n, err
+}
+
+// Lines will return the lines sent so far.
+func (f *fakeAdapter) Lines() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string(nil), f.lines...)
+}
+
+func fakeAxis(p *coord.Point, w byte) *float64 {
+	switch w {
+	case 'X':
+		return &p.X
+	case 'Y':
+		return &p.Y
+	}
+	return &p.Z
+}
+
+func (f *fakeAdapter) run(line string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.lines = append(f.lines, line)
+	if line == "$X" {
+		f.alarm = false
+		return nil
+	}
+	if f.alarm {
+		return errors.New("error:9")
+	}
+	if line == "" || line[0] == '$' {
+		return nil
+	}
+	b, err := gcode.NewParser(strings.NewReader(line)).Read()
+	if err != nil {
+		return err
+	}
+
+	for _, w := range b {
+		if w.W == 'M' && w.Arg == 0 {
+			// like Grbl, the M0 line completes once cycle start is pressed
+			f.status = "Hold:0"
+			f.mx.Unlock()
+			<-f.resume
+			f.mx.Lock()
+			f.status = "Idle"
+			return nil
+		}
+	}
+
+	var machine, setOffset bool
+	var probe float64
+	for _, w := range b {
+		if w.W != 'G' {
+			continue
+		}
+		switch w.Arg {
+		case 90:
+			f.relative = false
+		case 91:
+			f.relative = true
+		case 53:
+			machine = true
+		case 92:
+			setOffset = true
+		case 38.2, 38.3, 38.4, 38.5:
+			probe = w.Arg
+		case 43.1:
+			// tool length offsets are not simulated
+			return nil
+		}
+	}
+
+	target := f.pos
+	for _, w := range b {
+		if !w.IsAxis() {
+			continue
+		}
+		switch {
+		case setOffset:
+			*fakeAxis(&f.wco, w.W) = *fakeAxis(&f.pos, w.W) - w.Arg
+		case machine:
+			// like Grbl, G53 ignores the distance mode
+			*fakeAxis(&target, w.W) = w.Arg
+		case f.relative:
+			*fakeAxis(&target, w.W) += w.Arg
+		default:
+			*fakeAxis(&target, w.W) = w.Arg + *fakeAxis(&f.wco, w.W)
+		}
+	}
+	if setOffset {
+		return nil
+	}
+	if probe == 0 {
+		f.pos = target
+		return nil
+	}
+
+	// only probing down toward the surface is simulated
+	z := f.surface(target.X, target.Y)
+	if probe <= 38.3 && z <= f.pos.Z && z >= target.Z {
+		f.pos = target
+		f.pos.Z = z
+		f.probes = append(f.probes, ProbeResult{Point: f.pos, Valid: true})
+		return nil
+	}
+	f.pos = target
+	f.probes = append(f.probes, ProbeResult{Point: f.pos})
+	if probe == 38.2 || probe == 38.4 {
+		f.alarm = true
+		return errors.New("ALARM:5")
+	}
+	return nil
+}
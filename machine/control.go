@@ -0,0 +1,73 @@
+package machine
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mastercactapus/gcnc/gcode"
+)
+
+// Pause will feed hold the machine.
+func (m *Machine) Pause() error { return m.Adapter.WriteByte('!') }
+
+// Resume will continue after a feed hold.
+func (m *Machine) Resume() error { return m.Adapter.WriteByte('~') }
+
+// Stop will end a running program in a controlled way.
+//
+// Motion is feed held, then the controller is reset to discard anything queued, which
+// stops the spindle and coolant without losing position. The tool is then retracted
+// to safeZ (in machine coordinates) and any tool length offset is restored.
+func (m *Machine) Stop(safeZ float64) error {
+	tlo := m.CurrentState().TLO
+
+	err := m.Pause()
+	if err != nil {
+		return err
+	}
+	err = m.waitStatus(30*time.Second, "Hold:0", "Idle")
+	if err != nil {
+		return err
+	}
+	err = m.Adapter.WriteByte(0x18)
+	if err != nil {
+		return err
+	}
+	err = m.waitStatus(5*time.Second, "Idle")
+	if err != nil {
+		return err
+	}
+
+	err = m.runBlocks([]gcode.Block{
+		{{W: 'M', Arg: 5}, {W: 'M', Arg: 9}},
+		{{W: 'G', Arg: 53}, {W: 'G', Arg: 0}, {W: 'Z', Arg: safeZ}},
+	})
+	if err != nil {
+		return err
+	}
+	if tlo != 0 {
+		return m.SetTLO(tlo)
+	}
+	return nil
+}
+
+// waitStatus will wait for the machine to report one of status.
+func (m *Machine) waitStatus(timeout time.Duration, status ...string) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		cur := m.CurrentState().Status
+		for _, s := range status {
+			if cur == s {
+				return nil
+			}
+		}
+		select {
+		case <-t.C:
+			return errors.New("timeout waiting for machine status " + status[0] + ", got " + cur)
+		case <-tick.C:
+		}
+	}
+}
@@ -71,3 +71,28 @@ func parseStatus(stat machine.State, data string) (*machine.State, error) {
 	}
 	return &stat, nil
 }
+
+// parseParam will parse a line of `$#` output, updating the tool length offset.
+//
+// It returns false if data is not a parameter (e.g. a probe result).
+func parseParam(stat machine.State, data string) (*machine.State, bool, error) {
+	data = strings.TrimSpace(data)
+	data = strings.TrimPrefix(data, "[")
+	data = strings.TrimSuffix(data, "]")
+	parts := strings.SplitN(data, ":", 2)
+	switch parts[0] {
+	case "G54", "G55", "G56", "G57", "G58", "G59", "G28", "G30", "G92":
+		return &stat, true, nil
+	case "TLO":
+		if len(parts) != 2 {
+			return nil, true, errors.New("invalid TLO message")
+		}
+		var err error
+		stat.TLO, err = strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, true, err
+		}
+		return &stat, true, nil
+	}
+	return nil, false, nil
+}
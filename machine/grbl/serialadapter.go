@@ -3,6 +3,7 @@ package grbl
 import (
 	"io"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -68,6 +69,15 @@ func (adapter *SerialAdapter) CurrentState() machine.State {
 	adapter.mx.Unlock()
 	return state
 }
+func (adapter *SerialAdapter) setState(state machine.State) {
+	adapter.mx.Lock()
+	adapter.last = state
+	adapter.mx.Unlock()
+	select {
+	case adapter.state <- state:
+	default:
+	}
+}
 func (adapter *SerialAdapter) loop() {
 	for {
 		select {
@@ -78,19 +88,24 @@ func (adapter *SerialAdapter) loop() {
 			if len(data) == 0 {
 				continue
 			}
-			if data[0] == '<' {
+			if strings.HasPrefix(data, "Grbl") {
+				// a reset clears the tool length offset
+				stat := adapter.last
+				stat.TLO = 0
+				adapter.setState(stat)
+			} else if data[0] == '<' {
 				stat, err := parseStatus(adapter.last, data)
 				if err != nil {
 					log.Println("ERROR: parse status:", err)
 					continue
 				}
-				adapter.mx.Lock()
-				adapter.last = *stat
-				adapter.mx.Unlock()
-				select {
-				case adapter.state <- adapter.last:
-				default:
+				adapter.setState(*stat)
+			} else if stat, ok, err := parseParam(adapter.last, data); ok {
+				if err != nil {
+					log.Println("ERROR: parse param:", err)
+					continue
 				}
+				adapter.setState(*stat)
 			} else if data[0] == '[' {
 				prb, err := parseProbe(data)
 				if err != nil {
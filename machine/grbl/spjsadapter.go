@@ -87,13 +87,24 @@ func (adapter *SPJSAdapter) loop() {
 		case resp := <-adapter.sp.Messages():
 			switch msg := resp.(type) {
 			case *spjs.DataFrame:
-				if msg.Data[0] == '<' {
+				if strings.HasPrefix(msg.Data, "Grbl") {
+					// a reset clears the tool length offset
+					stat := adapter.last
+					stat.TLO = 0
+					adapter.setMachineState(stat)
+				} else if msg.Data[0] == '<' {
 					stat, err := parseStatus(adapter.last, msg.Data)
 					if err != nil {
 						log.Println("ERROR: parse status:", err)
 						continue
 					}
 					adapter.setMachineState(*stat)
+				} else if stat, ok, err := parseParam(adapter.last, msg.Data); ok {
+					if err != nil {
+						log.Println("ERROR: parse param:", err)
+						continue
+					}
+					adapter.setMachineState(*stat)
 				} else if msg.Data[0] == '[' {
 					prb, err := parseProbe(msg.Data)
 					if err != nil {
@@ -0,0 +1,173 @@
+package machine
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrHoldAborted is returned when the operator aborts at a hold.
+var ErrHoldAborted = errors.New("aborted by operator")
+
+// ErrUnknownHold is returned when resolving a hold that is not active.
+var ErrUnknownHold = errors.New("unknown hold")
+
+// HoldKind is the reason for a hold.
+type HoldKind string
+
+// Hold kinds.
+const (
+	HoldInsertProbe HoldKind = "insert-probe"
+	HoldRemoveProbe HoldKind = "remove-probe"
+	HoldChangeTool  HoldKind = "change-tool"
+)
+
+// Hold choices.
+const (
+	HoldContinue = "continue"
+	HoldAbort    = "abort"
+)
+
+// A Hold is a prompt for the operator. The machine waits (with M0) until it
+// is resolved, or cycle start is pressed on the machine.
+type Hold struct {
+	ID      int
+	Kind    HoldKind
+	Message string
+
+	// Choices are the responses the operator can pick from, the first is the default.
+	Choices []string `json:",omitempty"`
+
+	// Done is set when the hold is over.
+	Done bool `json:",omitempty"`
+
+	choice chan string
+}
+
+// Holds will return the active holds.
+func (m *Machine) Holds() []Hold {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	res := make([]Hold, 0, len(m.holds))
+	for _, h := range m.holds {
+		res = append(res, *h)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// ResolveHold will answer an active hold with choice (or the default if empty)
+// and resume the machine.
+//
+// Holds are only active once the machine has stopped at them, so the resume is not lost.
+// Resolving a hold again resumes the machine without changing the choice.
+func (m *Machine) ResolveHold(id int, choice string) error {
+	m.mx.Lock()
+	h, ok := m.holds[id]
+	m.mx.Unlock()
+	if !ok {
+		return ErrUnknownHold
+	}
+	if choice == "" {
+		choice = h.Choices[0]
+	}
+	valid := false
+	for _, c := range h.Choices {
+		valid = valid || c == choice
+	}
+	if !valid {
+		return errors.New("invalid choice: " + choice)
+	}
+
+	select {
+	case h.choice <- choice:
+	default:
+		// already resolved, but still waiting
+	}
+	return m.Adapter.WriteByte('~')
+}
+
+// prompt will hold the machine until the operator responds, returning their choice.
+//
+// If cycle start is pressed on the machine, the first choice is returned.
+//
+// The hold is published once the machine reports it has stopped (Hold:0), as
+// queued motion runs before the M0 takes effect.
+func (m *Machine) prompt(kind HoldKind, message string, choices ...string) (string, error) {
+	if len(choices) == 0 {
+		choices = []string{HoldContinue}
+	}
+	m.mx.Lock()
+	m.holdID++
+	h := &Hold{
+		ID:      m.holdID,
+		Kind:    kind,
+		Message: message,
+		Choices: choices,
+		choice:  make(chan string, 1),
+	}
+	m.mx.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Adapter.Write([]byte("M0\n"))
+		done <- err
+	}()
+
+	stopped, err := m.waitHold(done)
+	if stopped {
+		m.mx.Lock()
+		m.holds[h.ID] = h
+		m.mx.Unlock()
+		m.holdMessage <- *h
+
+		err = <-done
+
+		m.mx.Lock()
+		delete(m.holds, h.ID)
+		m.mx.Unlock()
+		ended := *h
+		ended.Done = true
+		m.holdMessage <- ended
+	}
+
+	if err != nil {
+		return "", err
+	}
+	select {
+	case c := <-h.choice:
+		return c, nil
+	default:
+		return choices[0], nil
+	}
+}
+
+// waitHold will wait for the machine to stop at a hold, returning true once it has.
+//
+// If done is received first (the M0 finished or failed), false is returned with its error.
+func (m *Machine) waitHold(done chan error) (bool, error) {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if m.CurrentState().Status == "Hold:0" {
+			return true, nil
+		}
+		select {
+		case err := <-done:
+			return false, err
+		case <-tick.C:
+		}
+	}
+}
+
+// hold will wait for the operator to continue, returning ErrHoldAborted if they abort.
+func (m *Machine) hold(kind HoldKind, message string) error {
+	c, err := m.prompt(kind, message, HoldContinue, HoldAbort)
+	if err != nil {
+		return err
+	}
+	if c == HoldAbort {
+		return ErrHoldAborted
+	}
+	return nil
+}
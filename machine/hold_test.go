@@ -0,0 +1,54 @@
+package machine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachine_Hold(t *testing.T) {
+	a := newFakeAdapter(coord.Point{}, coord.Point{}, nil)
+	m := NewMachine(a)
+
+	res := make(chan error, 1)
+	go func() { res <- m.hold(HoldChangeTool, "Insert T1.") }()
+
+	// published only once the machine has stopped
+	h := <-m.HoldMessage()
+	assert.Equal(t, "Hold:0", a.CurrentState().Status)
+	assert.False(t, h.Done)
+	assert.Equal(t, HoldChangeTool, h.Kind)
+	assert.Equal(t, []Hold{h}, m.Holds())
+
+	assert.Error(t, m.ResolveHold(h.ID, "skip"))
+	assert.Equal(t, ErrUnknownHold, m.ResolveHold(h.ID+1, ""))
+
+	assert.NoError(t, m.ResolveHold(h.ID, HoldAbort))
+	done := <-m.HoldMessage()
+	assert.True(t, done.Done)
+	assert.Equal(t, ErrHoldAborted, <-res)
+	assert.Empty(t, m.Holds())
+}
+
+func TestMachine_Hold_Resend(t *testing.T) {
+	a := newFakeAdapter(coord.Point{}, coord.Point{}, nil)
+	m := NewMachine(a)
+
+	res := make(chan error, 1)
+	go func() { res <- m.hold(HoldRemoveProbe, "Remove probe.") }()
+	h := <-m.HoldMessage()
+
+	// a choice made but not acted on (e.g. the resume was lost) can be sent again
+	h.choice <- HoldContinue
+	assert.NoError(t, m.ResolveHold(h.ID, HoldAbort))
+
+	<-m.HoldMessage()
+	select {
+	case err := <-res:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "hold did not resume")
+	}
+}
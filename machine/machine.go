@@ -1,6 +1,8 @@
 package machine
 
 import (
+	"sync"
+
 	"github.com/mastercactapus/gcnc/coord"
 	"github.com/mastercactapus/gcnc/gcode"
 )
@@ -8,22 +10,35 @@ import (
 type Machine struct {
 	Adapter
 
-	holdMessage chan string
+	holdMessage chan Hold
+
+	mx         sync.Mutex
+	tool       int
+	tools      Tools
+	toolChange *ToolChangeOptions
+	holds      map[int]*Hold
+	holdID     int
 }
 type State struct {
 	Status string
 	MPos   coord.Point
 	WCO    coord.Point
+
+	// TLO is the active tool length offset (G43.1), as last reported by the controller.
+	// Grbl includes it in WCO.Z.
+	TLO float64
 }
 
 func NewMachine(a Adapter) *Machine {
 	return &Machine{
 		Adapter:     a,
-		holdMessage: make(chan string),
+		holdMessage: make(chan Hold),
+		holds:       make(map[int]*Hold),
 	}
 }
 
-func (m *Machine) HoldMessage() chan string {
+// HoldMessage will return a channel of holds as they start and end.
+func (m *Machine) HoldMessage() chan Hold {
 	return m.holdMessage
 }
 
@@ -32,13 +47,6 @@ func (m *Machine) runBlocks(b []gcode.Block) error {
 	return err
 }
 
-func (m *Machine) hold(message string) error {
-	m.holdMessage <- message
-	_, err := m.Adapter.Write([]byte("M0\n"))
-	m.holdMessage <- "-"
-	return err
-}
-
 func generateGoTo(travelZ float64, pos coord.Point) []gcode.Block {
 	return []gcode.Block{
 		{
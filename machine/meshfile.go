@@ -0,0 +1,185 @@
+package machine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// MeshFileVersion is the current version of the mesh file format.
+//
+// Version 2 stores points relative to the work origin, earlier versions use machine coordinates.
+const MeshFileVersion = 2
+
+// MeshFile is a saved set of grid probe results, in work coordinates
+// so that it remains valid if the machine is re-homed.
+type MeshFile struct {
+	Version int
+	Name    string
+
+	// Units of the probe points, always "mm".
+	Units string
+
+	// WCO is the work coordinate offset at the time of probing.
+	WCO  coord.Point
+	Time time.Time
+
+	Options ProbeGridOptions
+	Points  []ProbeResult
+
+	// Zero is where Z was zeroed while probing, if it was. Absolute moves are leveled
+	// relative to the surface height here.
+	Zero *coord.Point `json:",omitempty"`
+
+	// References are points on the workpiece, probed when the mesh was created,
+	// used to re-register the mesh after the workpiece is moved.
+	References [3]*coord.Point
+
+	// Registration holds new measurements of References, the mesh is moved
+	// once all three are recorded.
+	Registration [3]*coord.Point
+}
+
+// NewMeshFile will create a mesh file from the results of a grid probe.
+//
+// The results are in machine coordinates, and are stored relative to wco.
+func NewMeshFile(name string, wco coord.Point, opt ProbeGridOptions, res []ProbeResult) *MeshFile {
+	points := make([]ProbeResult, len(res))
+	for i, r := range res {
+		points[i] = ProbeResult{Point: r.Sub(wco), Valid: r.Valid}
+	}
+	return &MeshFile{
+		Version: MeshFileVersion,
+		Name:    name,
+		Units:   "mm",
+		WCO:     wco,
+		Time:    time.Now(),
+		Options: opt,
+		Points:  points,
+	}
+}
+
+// ReadMeshFile will read a mesh file, converting older versions to work coordinates.
+//
+// Legacy files (a bare array of points) are accepted, points without a Valid
+// field are assumed to have made contact. As they did not record one, wco is
+// used as the work offset at the time of probing.
+func ReadMeshFile(r io.Reader, wco coord.Point) (*MeshFile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+
+	if !bytes.HasPrefix(data, []byte("[")) {
+		var mf MeshFile
+		err = json.Unmarshal(data, &mf)
+		if err != nil {
+			return nil, err
+		}
+		if mf.Version > MeshFileVersion {
+			return nil, errors.New("unsupported mesh file version")
+		}
+		if mf.Version < 2 {
+			mf.toWork(mf.WCO)
+		}
+		return &mf, nil
+	}
+
+	var legacy []struct {
+		coord.Point
+		Valid *bool
+	}
+	err = json.Unmarshal(data, &legacy)
+	if err != nil {
+		return nil, err
+	}
+	mf := &MeshFile{Units: "mm", WCO: wco, Points: make([]ProbeResult, len(legacy))}
+	for i, p := range legacy {
+		mf.Points[i] = ProbeResult{Point: p.Point, Valid: p.Valid == nil || *p.Valid}
+	}
+	mf.toWork(wco)
+	return mf, nil
+}
+
+// toWork will convert points from machine coordinates.
+func (mf *MeshFile) toWork(wco coord.Point) {
+	for i := range mf.Points {
+		mf.Points[i].Point = mf.Points[i].Sub(wco)
+	}
+}
+
+// MachineZero will return where Z was zeroed in machine coordinates for the given
+// work offset, or nil if it was not recorded.
+func (mf *MeshFile) MachineZero(wco coord.Point) *coord.Point {
+	if mf.Zero == nil {
+		return nil
+	}
+	p := mf.Zero.Add(wco)
+	return &p
+}
+
+// MachinePoints will return the points that made contact, in machine coordinates
+// for the given work offset.
+func (mf *MeshFile) MachinePoints(wco coord.Point) []coord.Point {
+	var res []coord.Point
+	for _, p := range mf.Points {
+		if p.Valid {
+			res = append(res, p.Add(wco))
+		}
+	}
+	return res
+}
+
+// Transform will move all points of the mesh, including references and Zero.
+func (mf *MeshFile) Transform(t coord.Transform) {
+	for i := range mf.Points {
+		mf.Points[i].Point = t.Apply(mf.Points[i].Point)
+	}
+	if mf.Zero != nil {
+		p := t.Apply(*mf.Zero)
+		mf.Zero = &p
+	}
+	for i, p := range mf.References {
+		if p != nil {
+			np := t.Apply(*p)
+			mf.References[i] = &np
+		}
+	}
+}
+
+// Register will record the new position of a reference point, in work coordinates.
+//
+// Once all three references have been measured, the mesh is moved to fit them and
+// true is returned.
+func (mf *MeshFile) Register(i int, p coord.Point) (bool, error) {
+	if i < 0 || i >= len(mf.References) {
+		return false, errors.New("invalid reference index")
+	}
+	if mf.References[i] == nil {
+		return false, errors.New("reference point has not been set")
+	}
+	mf.Registration[i] = &p
+
+	var from, to []coord.Point
+	for n, ref := range mf.References {
+		if ref == nil || mf.Registration[n] == nil {
+			return false, nil
+		}
+		from = append(from, *ref)
+		to = append(to, *mf.Registration[n])
+	}
+
+	t, err := coord.FitRigid(from, to)
+	if err != nil {
+		return false, err
+	}
+	mf.Transform(t)
+	mf.Registration = [3]*coord.Point{}
+	return true, nil
+}
@@ -9,23 +9,43 @@ import (
 	"github.com/mastercactapus/gcnc/meshlevel"
 )
 
-func (m *Machine) ReadFromLevel(r io.Reader, granularity float64, points []coord.Point) (int64, error) {
+// LevelOptions configure mesh leveling of a program.
+type LevelOptions struct {
+	Granularity float64
+
+	// Tolerance is the max Z error allowed, see meshlevel.Config.
+	Tolerance float64
+
+	// Edge controls how moves outside of the probed area are handled.
+	Edge meshlevel.EdgeMode
+
+	// Model selects how the surface is interpolated between probe points.
+	Model meshlevel.Model
+
+	// Zero is the machine XY where Z was zeroed, see meshlevel.Config.
+	Zero *coord.Point
+}
+
+func (m *Machine) ReadFromLevel(r io.Reader, opt LevelOptions, points []coord.Point) (int64, error) {
 	stat := m.CurrentState()
 	if stat.Status != "Idle" {
 		return 0, errors.New("machine not idle")
 	}
 
-	mesh, err := meshlevel.NewMesh(points)
+	z, err := meshlevel.NewZOffsetter(opt.Model, points)
 	if err != nil {
 		return 0, err
 	}
 	cfg := meshlevel.Config{
-		ZOffsetter: mesh,
+		ZOffsetter: z,
 
 		MPos: stat.MPos,
 		WCO:  stat.WCO,
+		Zero: opt.Zero,
 
-		Granularity: granularity,
+		Granularity: opt.Granularity,
+		Tolerance:   opt.Tolerance,
+		Edge:        opt.Edge,
 		Reader:      gcode.NewParser(r),
 	}
 
@@ -2,14 +2,23 @@ package machine
 
 import (
 	"errors"
+	"math"
 
 	"github.com/mastercactapus/gcnc/coord"
 	"github.com/mastercactapus/gcnc/gcode"
 )
 
+// ErrProbeFailed is returned when a probe does not make contact (or, for G38.4 and G38.5,
+// does not break it) within its travel. Any alarm raised by the controller is cleared.
+var ErrProbeFailed = errors.New("probe failed")
+
 type ProbeResult struct {
 	coord.Point
 	Valid bool
+
+	// Spread is the difference between the highest and lowest Z when averaging
+	// multiple touches.
+	Spread float64 `json:",omitempty"`
 }
 
 // ProbeOptions configure a straight z-probe operation.
@@ -22,6 +31,21 @@ type ProbeOptions struct {
 	FeedRate  float64
 	MaxTravel float64
 
+	// SeekFeedRate, if set, will do a fast probe first, then back off by Retract
+	// and touch again at FeedRate.
+	SeekFeedRate float64
+
+	// Retract is the distance to back off between touches. Defaults to 1.
+	Retract float64
+
+	// Touches is the number of touches at FeedRate to average. Defaults to 1.
+	Touches int
+
+	// Command is the probe command to use: 38.2 (the default) or 38.3 to probe
+	// toward contact, 38.4 or 38.5 to probe away from it. 38.2 and 38.4 raise an alarm
+	// on failure; 38.3 and 38.5 do not, allowing grid probing to continue past a failed point.
+	Command float64
+
 	// If true, execute a feed hold before probing
 	Wait bool
 }
@@ -29,7 +53,7 @@ type ProbeOptions struct {
 // ProbeZ will perform a straigt z-probe from the current location.
 func (m *Machine) ProbeZ(opt ProbeOptions) (*ProbeResult, error) {
 	if opt.Wait {
-		err := m.hold("Attach Z-Probe to spindle.")
+		err := m.hold(HoldInsertProbe, "Attach Z-Probe to spindle.")
 		if err != nil {
 			return nil, err
 		}
@@ -39,29 +63,191 @@ func (m *Machine) ProbeZ(opt ProbeOptions) (*ProbeResult, error) {
 		return nil, errors.New("machine not idle")
 	}
 
-	m.Adapter.ResetProbes()
-	err := m.runBlocks(opt.generate(stat.MPos))
+	res, err := m.runProbe(opt, opt.touchCommand('Z'), 'Z')
 	if err != nil {
+		m.runBlocks([]gcode.Block{opt.lift(stat.MPos.Z)})
 		return nil, err
 	}
-	p := m.Adapter.Probes()
-	if len(p) == 0 {
-		return nil, errors.New("no probe data returned")
+
+	var b []gcode.Block
+	if opt.ZeroZAxis {
+		b = append(b, gcode.Block{
+			{W: 'G', Arg: 92},
+			{W: 'Z', Arg: opt.Offset},
+		})
+	}
+	err = m.runBlocks(append(b, opt.lift(stat.MPos.Z)))
+	if err != nil {
+		return nil, err
 	}
+	return &res, nil
+}
 
-	return &p[0], nil
+// command will return the probe command to use.
+func (opt ProbeOptions) command() float64 {
+	if opt.Command == 0 {
+		return 38.2
+	}
+	return opt.Command
 }
 
-// ProbeCommand will return a command to do a Z-probe.
-func (opt ProbeOptions) probeCommand(zero bool, lift float64) []gcode.Block {
-	b := []gcode.Block{
-		{
+// alarms will check if a failed probe raises an alarm.
+func (opt ProbeOptions) alarms() bool {
+	return opt.command() == 38.2 || opt.command() == 38.4
+}
+
+// unlock will clear an alarm state.
+func (m *Machine) unlock() error {
+	_, err := m.Adapter.Write([]byte("$X\n"))
+	return err
+}
+
+// runProbe will run a probe command, returning the combined result.
+//
+// If contact is not made, ErrProbeFailed is returned with the result, after clearing any alarm.
+func (m *Machine) runProbe(opt ProbeOptions, b []gcode.Block, axis byte) (ProbeResult, error) {
+	switch opt.command() {
+	case 38.2, 38.3, 38.4, 38.5:
+	default:
+		return ProbeResult{}, errors.New("unsupported probe command")
+	}
+
+	m.ResetProbes()
+	runErr := m.runBlocks(b)
+	probes := m.Probes()
+	res, err := opt.combineAxis(probes, axis)
+
+	failed := err == nil && !res.Valid
+	for _, p := range probes {
+		failed = failed || !p.Valid
+	}
+	if failed && opt.alarms() {
+		// the controller is locked until cleared, leaving anything else queued unrun
+		uErr := m.unlock()
+		if uErr != nil {
+			return res, uErr
+		}
+	}
+	if failed {
+		return res, ErrProbeFailed
+	}
+	if runErr != nil {
+		return res, runErr
+	}
+	return res, err
+}
+
+// lift will return a block to move to z in machine coordinates.
+func (opt ProbeOptions) lift(z float64) gcode.Block {
+	return gcode.Block{
+		{W: 'G', Arg: 53},
+		{W: 'G', Arg: 0},
+		{W: 'Z', Arg: z},
+	}
+}
+
+func (opt ProbeOptions) retract() float64 {
+	if opt.Retract == 0 {
+		return 1
+	}
+	return opt.Retract
+}
+func (opt ProbeOptions) touches() int {
+	if opt.Touches < 1 {
+		return 1
+	}
+	return opt.Touches
+}
+
+// probeCount will return the number of probe results a single probe command generates.
+func (opt ProbeOptions) probeCount() int {
+	if opt.SeekFeedRate > 0 {
+		return opt.touches() + 1
+	}
+	return opt.touches()
+}
+
+// combine will return the average of the precise touches from the results of a single probe command.
+func (opt ProbeOptions) combine(probes []ProbeResult) (ProbeResult, error) {
+	return opt.combineAxis(probes, 'Z')
+}
+
+// combineAxis is like combine, reporting the spread along axis.
+func (opt ProbeOptions) combineAxis(probes []ProbeResult, axis byte) (ProbeResult, error) {
+	if len(probes) < opt.probeCount() {
+		return ProbeResult{}, errors.New("no probe data returned")
+	}
+	if opt.SeekFeedRate > 0 {
+		probes = probes[1:]
+	}
+	probes = probes[:opt.touches()]
+
+	res := ProbeResult{Valid: true}
+	lo, hi := axisValue(probes[0].Point, axis), axisValue(probes[0].Point, axis)
+	for _, p := range probes {
+		res.Point = res.Add(p.Point)
+		res.Valid = res.Valid && p.Valid
+		lo = math.Min(lo, axisValue(p.Point, axis))
+		hi = math.Max(hi, axisValue(p.Point, axis))
+	}
+	res.Point = res.Div(float64(len(probes)))
+	res.Spread = hi - lo
+	return res, nil
+}
+
+// axisValue will return the component of p for axis (X, Y or Z).
+func axisValue(p coord.Point, axis byte) float64 {
+	switch axis {
+	case 'X':
+		return p.X
+	case 'Y':
+		return p.Y
+	}
+	return p.Z
+}
+
+// touchCommand will return a command to probe along axis by MaxTravel,
+// using a seek and multiple touches as configured. It ends at the last touch.
+func (opt ProbeOptions) touchCommand(axis byte) []gcode.Block {
+	var b []gcode.Block
+	touch := func(dist, feed float64) {
+		b = append(b, gcode.Block{
 			{W: 'G', Arg: 91},
-			{W: 'G', Arg: 38.2},
-			{W: 'Z', Arg: opt.MaxTravel},
-			{W: 'F', Arg: opt.FeedRate},
-		},
+			{W: 'G', Arg: opt.command()},
+			{W: axis, Arg: dist},
+			{W: 'F', Arg: feed},
+		})
 	}
+	// back off away from the direction of travel
+	back := -math.Copysign(opt.retract(), opt.MaxTravel)
+	retract := func() {
+		b = append(b, gcode.Block{
+			{W: 'G', Arg: 91},
+			{W: 'G', Arg: 0},
+			{W: axis, Arg: back},
+		})
+	}
+
+	if opt.SeekFeedRate > 0 {
+		touch(opt.MaxTravel, opt.SeekFeedRate)
+		retract()
+		touch(-2*back, opt.FeedRate)
+	} else {
+		touch(opt.MaxTravel, opt.FeedRate)
+	}
+	for i := 1; i < opt.touches(); i++ {
+		retract()
+		touch(-2*back, opt.FeedRate)
+	}
+	return b
+}
+
+// ProbeCommand will return a command to do a Z-probe.
+//
+// With multiple touches, the Z-axis is zeroed at the last one.
+func (opt ProbeOptions) probeCommand(zero bool, lift float64) []gcode.Block {
+	b := opt.touchCommand('Z')
+
 	if zero {
 		b = append(b, gcode.Block{
 			{W: 'G', Arg: 92},
@@ -0,0 +1,163 @@
+package machine
+
+import (
+	"math"
+	"sort"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// probeCell is a rectangle of an adaptive probe grid, in machine coordinates.
+type probeCell struct {
+	x0, y0, x1, y1 float64
+}
+
+func (c probeCell) corners() [4]coord.Point {
+	return [4]coord.Point{
+		{X: c.x0, Y: c.y0},
+		{X: c.x1, Y: c.y0},
+		{X: c.x0, Y: c.y1},
+		{X: c.x1, Y: c.y1},
+	}
+}
+func (c probeCell) center() coord.Point {
+	return coord.Point{X: (c.x0 + c.x1) / 2, Y: (c.y0 + c.y1) / 2}
+}
+
+// split will divide the cell into quarters.
+func (c probeCell) split() []probeCell {
+	mx, my := (c.x0+c.x1)/2, (c.y0+c.y1)/2
+	return []probeCell{
+		{c.x0, c.y0, mx, my},
+		{mx, c.y0, c.x1, my},
+		{c.x0, my, mx, c.y1},
+		{mx, my, c.x1, c.y1},
+	}
+}
+
+// probeKey identifies a probe location, rounded to avoid floating point mismatches.
+type probeKey struct{ x, y int64 }
+
+func keyOf(p coord.Point) probeKey {
+	return probeKey{int64(math.Round(p.X * 10000)), int64(math.Round(p.Y * 10000))}
+}
+
+// sortProbePoints will order points row by row, alternating direction, to reduce travel.
+func sortProbePoints(points []coord.Point) {
+	sort.Slice(points, func(i, j int) bool {
+		yi, yj := math.Round(points[i].Y*10000), math.Round(points[j].Y*10000)
+		if yi != yj {
+			return yi < yj
+		}
+		return points[i].X < points[j].X
+	})
+	for start, row := 0, 0; start < len(points); row++ {
+		end := start
+		for end < len(points) && math.Round(points[end].Y*10000) == math.Round(points[start].Y*10000) {
+			end++
+		}
+		if row%2 == 1 {
+			for i, j := start, end-1; i < j; i, j = i+1, j-1 {
+				points[i], points[j] = points[j], points[i]
+			}
+		}
+		start = end
+	}
+}
+
+// probeZAdaptive will probe a coarse grid, starting at origin, then repeatedly probe the center of each cell,
+// splitting it into quarters if the center deviates from the bilinear prediction of its
+// corners by more than opt.Tolerance, until cells reach opt.MinSpacing.
+func (m *Machine) probeZAdaptive(opt ProbeGridOptions, origin coord.Point, zHeight float64) ([]ProbeResult, error) {
+	if opt.Tolerance == 0 {
+		opt.Tolerance = 0.05
+	}
+	if opt.MinSpacing == 0 {
+		opt.MinSpacing = opt.Granularity / 4
+	}
+
+	xyDist := math.Sqrt(opt.Granularity * opt.Granularity / 2)
+	xCount := int(math.Ceil(opt.DistanceX / xyDist))
+	yCount := int(math.Ceil(opt.DistanceY / xyDist))
+	stepX, stepY := opt.DistanceX/float64(xCount), opt.DistanceY/float64(yCount)
+
+	var cells []probeCell
+	for y := 0; y < yCount; y++ {
+		for x := 0; x < xCount; x++ {
+			x0, y0 := origin.X+stepX*float64(x), origin.Y+stepY*float64(y)
+			cells = append(cells, probeCell{x0, y0, x0 + stepX, y0 + stepY})
+		}
+	}
+
+	known := make(map[probeKey]ProbeResult)
+	var probed []coord.Point
+	for len(cells) > 0 {
+		var need []coord.Point
+		queued := make(map[probeKey]bool)
+		add := func(p coord.Point) {
+			if !opt.includes(p.X, p.Y) {
+				return
+			}
+			k := keyOf(p)
+			if _, ok := known[k]; ok || queued[k] {
+				return
+			}
+			queued[k] = true
+			need = append(need, p)
+		}
+		for _, c := range cells {
+			for _, p := range c.corners() {
+				add(p)
+			}
+			add(c.center())
+		}
+		sortProbePoints(need)
+
+		if len(need) == 0 {
+			break
+		}
+		res, err := m.probeZPoints(need, opt.ProbeOptions, zHeight, opt.Completed, opt.OnProbe)
+		for i, r := range res {
+			known[keyOf(need[i])] = r
+		}
+		probed = append(probed, need[:len(res)]...)
+		if err != nil {
+			return adaptiveResults(known, probed), err
+		}
+
+		var next []probeCell
+		for _, c := range cells {
+			if (c.x1-c.x0)/2 < opt.MinSpacing || (c.y1-c.y0)/2 < opt.MinSpacing {
+				continue
+			}
+			center := known[keyOf(c.center())]
+			if !center.Valid {
+				continue
+			}
+			var sum float64
+			valid := true
+			for _, p := range c.corners() {
+				r := known[keyOf(p)]
+				valid = valid && r.Valid
+				sum += r.Z
+			}
+			if !valid || math.Abs(center.Z-sum/4) <= opt.Tolerance {
+				continue
+			}
+			next = append(next, c.split()...)
+		}
+		cells = next
+	}
+
+	return adaptiveResults(known, probed), nil
+}
+
+// adaptiveResults will return the results for probed points in scan order.
+func adaptiveResults(known map[probeKey]ProbeResult, probed []coord.Point) []ProbeResult {
+	sortProbePoints(probed)
+	res := make([]ProbeResult, len(probed))
+	for i, p := range probed {
+		res[i] = known[keyOf(p)]
+	}
+	return res
+}
@@ -14,52 +14,192 @@ type ProbeGridOptions struct {
 
 	DistanceX, DistanceY float64
 	Granularity          float64
+
+	// Adaptive will start with a grid of Granularity and add probes where the surface
+	// is not flat, instead of using a uniform grid.
+	Adaptive bool
+
+	// Tolerance is the max deviation of a cell's center from the average of its corners
+	// before it is subdivided in adaptive mode. Defaults to 0.05.
+	Tolerance float64
+
+	// MinSpacing is the smallest distance between probes in adaptive mode. Defaults to Granularity/4.
+	MinSpacing float64
+
+	// Origin is the machine XY of the corner of the grid. Defaults to the current position.
+	Origin *coord.Point `json:",omitempty"`
+
+	// Include, if set, will skip probe points (in machine coordinates) it returns false for.
+	Include func(x, y float64) bool `json:"-"`
+
+	// Completed are results from a previous, interrupted, run of the same grid.
+	// Points with a result are not probed again.
+	Completed []ProbeResult `json:"-"`
+
+	// OnProbe, if set, is called with each new result as it is measured.
+	OnProbe func(ProbeResult) `json:"-"`
+}
+
+// GridProgress is the state of an unfinished grid probe, saved so that it can be resumed.
+type GridProgress struct {
+	// Origin, DistanceX/Y and Granularity place the grid points, they are reused on resume
+	// so that the grid is not moved if the head has.
+	Origin               coord.Point
+	DistanceX, DistanceY float64
+	Granularity          float64
+
+	// Zero is the machine XY where Z was zeroed, if it was.
+	Zero *coord.Point `json:",omitempty"`
+
+	Results []ProbeResult
+}
+
+// NewGridProgress will start tracking a grid probe from mPos, setting the
+// origin of opt to keep it in place.
+func NewGridProgress(opt *ProbeGridOptions, mPos coord.Point) *GridProgress {
+	origin := opt.origin(mPos)
+	opt.Origin = &coord.Point{X: origin.X, Y: origin.Y}
+	p := &GridProgress{
+		Origin:      *opt.Origin,
+		DistanceX:   opt.DistanceX,
+		DistanceY:   opt.DistanceY,
+		Granularity: opt.Granularity,
+	}
+	if opt.ZeroZAxis {
+		p.Zero = &coord.Point{X: mPos.X, Y: mPos.Y}
+	}
+	return p
+}
+
+// Resume will set opt to continue the probe. Options that place the grid default to
+// the saved ones, an error is returned if they are set and do not match.
+func (p *GridProgress) Resume(opt *ProbeGridOptions) error {
+	same := func(a, b float64) bool { return math.Abs(a-b) <= resumeTolerance }
+	if opt.Origin != nil && (!same(opt.Origin.X, p.Origin.X) || !same(opt.Origin.Y, p.Origin.Y)) {
+		return errors.New("origin does not match the grid being resumed")
+	}
+	if (opt.DistanceX != 0 && !same(opt.DistanceX, p.DistanceX)) || (opt.DistanceY != 0 && !same(opt.DistanceY, p.DistanceY)) {
+		return errors.New("distance does not match the grid being resumed")
+	}
+	if opt.Granularity != 0 && !same(opt.Granularity, p.Granularity) {
+		return errors.New("granularity does not match the grid being resumed")
+	}
+
+	origin := p.Origin
+	opt.Origin = &origin
+	opt.DistanceX, opt.DistanceY = p.DistanceX, p.DistanceY
+	opt.Granularity = p.Granularity
+	opt.Completed = append([]ProbeResult(nil), p.Results...)
+	return nil
+}
+
+// origin will return the corner of the grid.
+func (opt ProbeGridOptions) origin(mPos coord.Point) coord.Point {
+	if opt.Origin == nil {
+		return mPos
+	}
+	return coord.Point{X: opt.Origin.X, Y: opt.Origin.Y, Z: mPos.Z}
+}
+
+// includes will check if a probe point should be used.
+func (opt ProbeGridOptions) includes(x, y float64) bool {
+	return opt.Include == nil || opt.Include(x, y)
 }
 
 // ProbeZGrid will perform a grid of straight z-probes.
-func (m *Machine) ProbeZGrid(opt ProbeGridOptions) ([]ProbeResult, error) {
+//
+// Results are in machine coordinates, and are returned with the work offset they were taken with
+// (after Z is zeroed, if ZeroZAxis is set).
+//
+// Points are probed one at a time; on error, the results collected so far are returned with it
+// and can be passed as Completed to resume.
+func (m *Machine) ProbeZGrid(opt ProbeGridOptions) ([]ProbeResult, coord.Point, error) {
 	stat := m.CurrentState()
 	if stat.Status != "Idle" {
-		return nil, errors.New("machine not idle")
+		return nil, stat.WCO, errors.New("machine not idle")
 	}
+	wco := stat.WCO
 
-	m.ResetProbes()
-	err := m.runBlocks(opt.generateGridQuick(stat.MPos))
-	if err != nil {
-		return nil, err
+	if len(opt.Completed) > 0 {
+		// keep the same Z zero as the original run
+		opt.ZeroZAxis = false
 	}
 
-	startProbes := m.Probes()
-	if len(startProbes) == 0 {
-		return nil, errors.New("no probe data returned")
+	// the first probe is at the current position, Z is only zeroed if it makes contact
+	first, err := m.runProbe(opt.ProbeOptions, opt.touchCommand('Z'), 'Z')
+	if err == ErrProbeFailed && !opt.alarms() && !opt.ZeroZAxis {
+		err = nil
 	}
-
-	maxZ := startProbes[0].Z
-	for _, p := range startProbes[1:] {
-		maxZ = math.Max(maxZ, p.Z)
+	if err != nil {
+		m.runBlocks([]gcode.Block{opt.lift(stat.MPos.Z)})
+		return nil, wco, err
 	}
-	maxZ += 0.2
+	var b []gcode.Block
+	if opt.ZeroZAxis {
+		b = append(b, gcode.Block{
+			{W: 'G', Arg: 92},
+			{W: 'Z', Arg: opt.Offset},
+		})
+		wco.Z = first.Z - opt.Offset
+	}
+	err = m.runBlocks(append(b, opt.lift(stat.MPos.Z)))
+	if err != nil {
+		return nil, wco, err
+	}
+
+	m.ResetProbes()
+	err = m.runBlocks(opt.generateGridQuick(stat.MPos))
+	startProbes := append(m.Probes(), first)
 
-	err = m.runBlocks(opt.generateGridSequence(stat.MPos, maxZ))
+	maxZ := math.Inf(-1)
+	for _, p := range startProbes {
+		if p.Valid {
+			maxZ = math.Max(maxZ, p.Z)
+		} else if opt.alarms() {
+			uErr := m.unlock()
+			if uErr != nil {
+				return nil, wco, uErr
+			}
+			m.runBlocks([]gcode.Block{opt.lift(stat.MPos.Z)})
+			return nil, wco, ErrProbeFailed
+		}
+	}
 	if err != nil {
-		return nil, err
+		m.runBlocks([]gcode.Block{opt.lift(stat.MPos.Z)})
+		return nil, wco, err
+	}
+	if math.IsInf(maxZ, -1) {
+		return nil, wco, ErrProbeFailed
 	}
+	maxZ += 0.2
 
-	return m.Probes(), nil
+	var res []ProbeResult
+	if opt.Adaptive {
+		res, err = m.probeZAdaptive(opt, opt.origin(stat.MPos), maxZ)
+	} else {
+		res, err = m.probeZPoints(opt.gridPoints(stat.MPos), opt.ProbeOptions, maxZ, opt.Completed, opt.OnProbe)
+	}
+	return res, wco, err
 }
 
 // generateGridQuick creates gcode for a preliminary grid scan.
 //
-// It scans from the current height for 5 points (corners and center).
+// It scans from the current height for 4 points (the corners and center of the grid),
+// then returns to the current position. The current position itself is probed first,
+// by the caller.
 func (opt ProbeGridOptions) generateGridQuick(mPos coord.Point) []gcode.Block {
-	b := opt.probeCommand(opt.ZeroZAxis, mPos.Z)
+	var b []gcode.Block
 
+	origin := opt.origin(mPos)
 	probe := func(x, y float64) {
+		if !opt.includes(origin.X+x, origin.Y+y) {
+			return
+		}
 		b = append(b, gcode.Block{
 			{W: 'G', Arg: 53},
 			{W: 'G', Arg: 0},
-			{W: 'X', Arg: mPos.X + x},
-			{W: 'Y', Arg: mPos.Y + y},
+			{W: 'X', Arg: origin.X + x},
+			{W: 'Y', Arg: origin.Y + y},
 		})
 		b = append(b, opt.probeCommand(false, mPos.Z)...)
 	}
@@ -77,61 +217,137 @@ func (opt ProbeGridOptions) generateGridQuick(mPos coord.Point) []gcode.Block {
 	return b
 }
 
-// generateGridSequence will generate gcode to do a grid scan by granulartity.
+// gridPoints will return the points of a grid scan by granularity, in machine coordinates.
 //
-// It is intended to be used after GenerateGridQuick is run.
-//
-// It generates a scan where no two points are farther than granularity apart, returing to the
-// provided zHeight during scan, and back to mPos after.
-func (opt ProbeGridOptions) generateGridSequence(mPos coord.Point, zHeight float64) []gcode.Block {
-	opt.MaxTravel -= mPos.Z - zHeight
-
+// It generates a scan where no two points are farther than granularity apart,
+// alternating direction on each row.
+func (opt ProbeGridOptions) gridPoints(mPos coord.Point) []coord.Point {
 	xyDist := math.Sqrt(opt.Granularity * opt.Granularity / 2)
 
 	xCount := int(math.Ceil(opt.DistanceX / xyDist))
 	yCount := int(math.Ceil(opt.DistanceY / xyDist))
 
-	b := []gcode.Block{
-		{
-			{W: 'G', Arg: 53},
-			{W: 'G', Arg: 0},
-			{W: 'Z', Arg: zHeight},
-		},
-	}
-	probe := func(x, y float64) {
-		b = append(b, gcode.Block{
-			{W: 'G', Arg: 53},
-			{W: 'G', Arg: 0},
-			{W: 'X', Arg: mPos.X + x},
-			{W: 'Y', Arg: mPos.Y + y},
-		})
-		b = append(b, opt.probeCommand(false, zHeight)...)
-	}
-
+	origin := opt.origin(mPos)
+	var points []coord.Point
 	for y := 0; y <= yCount; y++ {
 		for x := 0; x <= xCount; x++ {
 			xVal := opt.DistanceX / float64(xCount) * float64(x)
 			if y%2 != 0 {
 				xVal = opt.DistanceX - xVal
 			}
-			probe(
-				xVal,
-				opt.DistanceY/float64(yCount)*float64(y),
-			)
+			p := coord.Point{
+				X: origin.X + xVal,
+				Y: origin.Y + opt.DistanceY/float64(yCount)*float64(y),
+			}
+			if opt.includes(p.X, p.Y) {
+				points = append(points, p)
+			}
 		}
 	}
+	return points
+}
 
-	b = append(b, gcode.Block{
+// SplitProbes will return the position of each probe result and whether it made contact.
+func SplitProbes(res []ProbeResult) ([]coord.Point, []bool) {
+	points := make([]coord.Point, len(res))
+	valid := make([]bool, len(res))
+	for i, r := range res {
+		points[i] = r.Point
+		valid[i] = r.Valid
+	}
+	return points, valid
+}
+
+// ProbeZPoints will perform a straight z-probe at each XY position in points (in machine coordinates),
+// returning to zHeight between each and back to the current position after.
+//
+// It is used to re-probe individual points of a grid.
+func (m *Machine) ProbeZPoints(points []coord.Point, opt ProbeOptions, zHeight float64) ([]ProbeResult, error) {
+	return m.probeZPoints(points, opt, zHeight, nil, nil)
+}
+
+// resumeTolerance is the max XY distance between a completed result and a probe point to reuse it.
+const resumeTolerance = 0.01
+
+// completedProbe will find a result in completed for the XY position of p.
+func completedProbe(completed []ProbeResult, p coord.Point) (ProbeResult, bool) {
+	for _, c := range completed {
+		if c.DistanceXY(p.X, p.Y) <= resumeTolerance {
+			return c, true
+		}
+	}
+	return ProbeResult{}, false
+}
+
+// probeZPoints will probe each point in turn, calling onProbe with each new result.
+//
+// Points with a result in completed are not probed again. On error, the results
+// collected so far are returned with it.
+func (m *Machine) probeZPoints(points []coord.Point, opt ProbeOptions, zHeight float64, completed []ProbeResult, onProbe func(ProbeResult)) ([]ProbeResult, error) {
+	stat := m.CurrentState()
+	if stat.Status != "Idle" {
+		return nil, errors.New("machine not idle")
+	}
+
+	opt.MaxTravel -= stat.MPos.Z - zHeight
+	err := m.runBlocks([]gcode.Block{{
 		{W: 'G', Arg: 53},
 		{W: 'G', Arg: 0},
-		{W: 'Z', Arg: mPos.Z},
-	},
-		gcode.Block{
+		{W: 'Z', Arg: zHeight},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]ProbeResult, 0, len(points))
+	for _, p := range points {
+		if c, ok := completedProbe(completed, p); ok {
+			res = append(res, c)
+			continue
+		}
+
+		b := append([]gcode.Block{{
 			{W: 'G', Arg: 53},
 			{W: 'G', Arg: 0},
-			{W: 'X', Arg: mPos.X},
-			{W: 'Y', Arg: mPos.Y},
-		})
+			{W: 'X', Arg: p.X},
+			{W: 'Y', Arg: p.Y},
+		}}, opt.touchCommand('Z')...)
 
-	return b
+		r, err := m.runProbe(opt, b, 'Z')
+		if err == ErrProbeFailed && !opt.alarms() {
+			// keep going, the point is recorded as invalid
+			err = nil
+		}
+		if err != nil {
+			m.runBlocks([]gcode.Block{opt.lift(zHeight)})
+			return res, err
+		}
+		err = m.runBlocks([]gcode.Block{opt.lift(zHeight)})
+		if err != nil {
+			return res, err
+		}
+		res = append(res, r)
+		if onProbe != nil {
+			onProbe(r)
+		}
+	}
+
+	err = m.runBlocks([]gcode.Block{
+		{
+			{W: 'G', Arg: 53},
+			{W: 'G', Arg: 0},
+			{W: 'Z', Arg: stat.MPos.Z},
+		},
+		{
+			{W: 'G', Arg: 53},
+			{W: 'G', Arg: 0},
+			{W: 'X', Arg: stat.MPos.X},
+			{W: 'Y', Arg: stat.MPos.Y},
+		},
+	})
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
 }
@@ -0,0 +1,141 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachine_ProbeZGrid_Failed(t *testing.T) {
+	gridOpt := func(cmd float64, zero bool) ProbeGridOptions {
+		return ProbeGridOptions{
+			ProbeOptions: ProbeOptions{ZeroZAxis: zero, FeedRate: 100, MaxTravel: -10, Command: cmd},
+			DistanceX:    10,
+			DistanceY:    10,
+			Granularity:  10,
+		}
+	}
+	start := coord.Point{Z: -5}
+	wco := coord.Point{X: -1, Y: -2, Z: -3}
+
+	t.Run("no contact at start", func(t *testing.T) {
+		a := newFakeAdapter(start, wco, func(x, y float64) float64 { return -100 })
+		m := NewMachine(a)
+
+		_, _, err := m.ProbeZGrid(gridOpt(38.3, true))
+		assert.Equal(t, ErrProbeFailed, err)
+
+		// Z must not be zeroed at the bottom of travel
+		s := a.CurrentState()
+		assert.Equal(t, wco, s.WCO)
+		assert.Equal(t, start, s.MPos)
+		for _, l := range a.Lines() {
+			assert.NotContains(t, l, "G92")
+		}
+	})
+
+	t.Run("alarm at corner", func(t *testing.T) {
+		a := newFakeAdapter(start, wco, func(x, y float64) float64 {
+			if x < 1 {
+				return -8
+			}
+			return -100
+		})
+		m := NewMachine(a)
+
+		_, _, err := m.ProbeZGrid(gridOpt(38.2, false))
+		assert.Equal(t, ErrProbeFailed, err)
+
+		s := a.CurrentState()
+		assert.Equal(t, "Idle", s.Status)
+		assert.Equal(t, start.Z, s.MPos.Z)
+		assert.Contains(t, a.Lines(), "$X")
+	})
+
+	t.Run("no contact without alarm", func(t *testing.T) {
+		a := newFakeAdapter(start, wco, func(x, y float64) float64 {
+			if x < 1 {
+				return -8
+			}
+			return -100
+		})
+		m := NewMachine(a)
+
+		res, _, err := m.ProbeZGrid(gridOpt(38.3, false))
+		assert.NoError(t, err)
+		var valid int
+		for _, r := range res {
+			if r.Valid {
+				valid++
+			}
+		}
+		assert.True(t, valid > 0 && valid < len(res))
+		assert.Equal(t, start, a.CurrentState().MPos)
+	})
+}
+
+func TestMachine_ProbeZGrid_ZeroZAxis(t *testing.T) {
+	// surface rises .1mm for every 1mm X
+	surface := func(x, y float64) float64 { return -10 + 0.1*(x+50) }
+	start := coord.Point{X: -50, Y: -50, Z: -5}
+	a := newFakeAdapter(start, coord.Point{X: -60, Y: -60, Z: -1}, surface)
+	m := NewMachine(a)
+
+	opt := ProbeGridOptions{
+		ProbeOptions: ProbeOptions{ZeroZAxis: true, Offset: 0.5, FeedRate: 100, MaxTravel: -10},
+		DistanceX:    10,
+		DistanceY:    10,
+		Granularity:  5,
+	}
+	res, wco, err := m.ProbeZGrid(opt)
+	assert.NoError(t, err)
+
+	// Z is zeroed (to Offset) at the first probe
+	assert.Equal(t, coord.Point{X: -60, Y: -60, Z: -10.5}, wco)
+	assert.Equal(t, a.CurrentState().WCO, wco)
+
+	mf := NewMeshFile("test", wco, opt, res)
+	assert.NotEmpty(t, mf.Points)
+	for _, p := range mf.Points {
+		assert.True(t, p.Valid)
+		assert.InDelta(t, 0.5+0.1*(p.X+wco.X+50), p.Z, 1e-9)
+	}
+	for _, p := range mf.MachinePoints(a.CurrentState().WCO) {
+		assert.InDelta(t, surface(p.X, p.Y), p.Z, 1e-9)
+	}
+}
+
+func TestMachine_ProbeZGrid_Resume(t *testing.T) {
+	surface := func(x, y float64) float64 { return -10 + 0.1*(x+50) }
+	wco := coord.Point{X: -60, Y: -60, Z: -1}
+	opt := ProbeGridOptions{
+		ProbeOptions: ProbeOptions{FeedRate: 100, MaxTravel: -10},
+		DistanceX:    10,
+		DistanceY:    10,
+		Granularity:  5,
+	}
+
+	a := newFakeAdapter(coord.Point{X: -50, Y: -50, Z: -5}, wco, surface)
+	progress := NewGridProgress(&opt, a.CurrentState().MPos)
+	full, _, err := NewMachine(a).ProbeZGrid(opt)
+	assert.NoError(t, err)
+	progress.Results = full[:len(full)/2]
+
+	// resumed from elsewhere on the grid, with only the probe options
+	a = newFakeAdapter(coord.Point{X: -45, Y: -47, Z: -5}, wco, surface)
+	resume := ProbeGridOptions{ProbeOptions: opt.ProbeOptions}
+	assert.NoError(t, progress.Resume(&resume))
+	var probed int
+	resume.OnProbe = func(ProbeResult) { probed++ }
+
+	res, _, err := NewMachine(a).ProbeZGrid(resume)
+	assert.NoError(t, err)
+	assert.Equal(t, full, res)
+	assert.Equal(t, len(full)-len(full)/2, probed)
+
+	resume = ProbeGridOptions{ProbeOptions: opt.ProbeOptions, DistanceX: 20}
+	assert.Error(t, progress.Resume(&resume))
+	resume = ProbeGridOptions{ProbeOptions: opt.ProbeOptions, Origin: &coord.Point{X: -45, Y: -47}}
+	assert.Error(t, progress.Resume(&resume))
+}
@@ -0,0 +1,375 @@
+package machine
+
+import (
+	"errors"
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+)
+
+// ProbeXYOptions configure edge, corner and center probing.
+//
+// MaxTravel is the distance to search for a surface, in any direction.
+type ProbeXYOptions struct {
+	ProbeOptions
+
+	// TipDiameter is the diameter of the probe tip, used to find the surface from the tip center.
+	TipDiameter float64
+
+	// Clearance is the distance to move past an edge, or above the surface, to get around it.
+	Clearance float64
+
+	// Depth is how far below the top surface to probe edges.
+	Depth float64
+
+	// Diameter is the approximate diameter of a boss.
+	Diameter float64
+
+	// SetZero will set the zero of the active work coordinate system to the result with G10 L20.
+	SetZero bool
+}
+
+// ProbeXYResult is the result of an edge, corner or center probe, in machine coordinates.
+//
+// Only the axes that were probed are set.
+type ProbeXYResult struct {
+	coord.Point
+
+	// Diameter is the measured diameter of a bore or boss.
+	Diameter float64 `json:",omitempty"`
+
+	// Angle is the rotation of an edge from the axis, in degrees.
+	Angle float64 `json:",omitempty"`
+
+	// Spread is the largest difference between touches of any single probe.
+	Spread float64 `json:",omitempty"`
+}
+
+// setAxis will return p with the component for axis set to v.
+func setAxis(p coord.Point, axis byte, v float64) coord.Point {
+	switch axis {
+	case 'X':
+		p.X = v
+	case 'Y':
+		p.Y = v
+	default:
+		p.Z = v
+	}
+	return p
+}
+
+// moveTo will do a rapid move to each point in machine coordinates, Z first if going up
+// or last if going down.
+func (m *Machine) moveTo(from coord.Point, points ...coord.Point) error {
+	var b []gcode.Block
+	move := func(w byte, v float64) {
+		b = append(b, gcode.Block{{W: 'G', Arg: 53}, {W: 'G', Arg: 0}, {W: w, Arg: v}})
+	}
+	for _, p := range points {
+		if p.Z > from.Z {
+			move('Z', p.Z)
+		}
+		b = append(b, gcode.Block{{W: 'G', Arg: 53}, {W: 'G', Arg: 0}, {W: 'X', Arg: p.X}, {W: 'Y', Arg: p.Y}})
+		if p.Z <= from.Z {
+			move('Z', p.Z)
+		}
+		from = p
+	}
+	return m.runBlocks(b)
+}
+
+// probeAxis will probe from start along axis in dir (+1 or -1), then return to start.
+//
+// The returned point is where the surface was found, accounting for the tip diameter.
+func (m *Machine) probeAxis(start coord.Point, axis byte, dir float64, travel float64, opt ProbeXYOptions) (ProbeResult, error) {
+	po := opt.ProbeOptions
+	po.MaxTravel = math.Copysign(travel, dir)
+
+	res, err := m.runProbe(po, po.touchCommand(axis), axis)
+	back := []gcode.Block{{{W: 'G', Arg: 53}, {W: 'G', Arg: 0}, {W: axis, Arg: axisValue(start, axis)}}}
+	if err != nil {
+		m.runBlocks(back)
+		return res, err
+	}
+	err = m.runBlocks(back)
+	if err != nil {
+		return res, err
+	}
+	if axis != 'Z' {
+		res.Point = setAxis(res.Point, axis, axisValue(res.Point, axis)+dir*opt.TipDiameter/2)
+	}
+	return res, nil
+}
+
+// setZero will set the active work coordinate system so that p is zero for the
+// given axes, with the machine at mPos.
+func (m *Machine) setZero(mPos, p coord.Point, axes string) error {
+	b := gcode.Block{{W: 'G', Arg: 10}, {W: 'L', Arg: 20}, {W: 'P', Arg: 0}}
+	for _, a := range []byte(axes) {
+		b = append(b, gcode.Word{W: a, Arg: axisValue(mPos, a) - axisValue(p, a)})
+	}
+	return m.runBlocks([]gcode.Block{b})
+}
+
+// direction will return the sign of each value, or an error if any are zero.
+func direction(dirs ...*float64) error {
+	for _, d := range dirs {
+		if *d == 0 {
+			return errors.New("direction must be +1 or -1")
+		}
+		*d = math.Copysign(1, *d)
+	}
+	return nil
+}
+
+// validateOutside will check the options used to probe from outside of the workpiece,
+// where a missing value would plunge into it.
+func (opt ProbeXYOptions) validateOutside(boss bool) error {
+	if opt.Clearance <= 0 {
+		return errors.New("clearance must be positive")
+	}
+	if opt.Depth <= 0 {
+		return errors.New("depth must be positive")
+	}
+	if boss && opt.Diameter <= 0 {
+		return errors.New("boss diameter must be positive")
+	}
+	return nil
+}
+
+// idlePosition will return the current position, if the machine is idle.
+func (m *Machine) idlePosition() (coord.Point, error) {
+	stat := m.CurrentState()
+	if stat.Status != "Idle" {
+		return coord.Point{}, errors.New("machine not idle")
+	}
+	return stat.MPos, nil
+}
+
+// ProbeEdge will find an edge by probing along axis (X or Y) in dir (+1 or -1) from the current position.
+func (m *Machine) ProbeEdge(axis byte, dir float64, opt ProbeXYOptions) (*ProbeXYResult, error) {
+	if axis != 'X' && axis != 'Y' {
+		return nil, errors.New("axis must be X or Y")
+	}
+	if err := direction(&dir); err != nil {
+		return nil, err
+	}
+	start, err := m.idlePosition()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := m.probeAxis(start, axis, dir, math.Abs(opt.MaxTravel), opt)
+	if err != nil {
+		return nil, err
+	}
+	res := &ProbeXYResult{Point: setAxis(coord.Point{}, axis, axisValue(r.Point, axis)), Spread: r.Spread}
+
+	if opt.SetZero {
+		err = m.setZero(start, res.Point, string(axis))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ProbeCorner will find an outside corner of the workpiece.
+//
+// It starts with the probe above the workpiece, within Clearance of the corner in
+// the direction of dirX and dirY (+1 or -1). The top is probed, then each edge is
+// probed from outside at Depth below it.
+func (m *Machine) ProbeCorner(dirX, dirY float64, opt ProbeXYOptions) (*ProbeXYResult, error) {
+	if err := direction(&dirX, &dirY); err != nil {
+		return nil, err
+	}
+	if err := opt.validateOutside(false); err != nil {
+		return nil, err
+	}
+	start, err := m.idlePosition()
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := m.probeAxis(start, 'Z', -1, math.Abs(opt.MaxTravel), opt)
+	if err != nil {
+		return nil, err
+	}
+	res := &ProbeXYResult{Point: coord.Point{Z: top.Z}, Spread: top.Spread}
+
+	for _, axis := range []byte{'X', 'Y'} {
+		dir := dirX
+		if axis == 'Y' {
+			dir = dirY
+		}
+		outside := setAxis(start, axis, axisValue(start, axis)+dir*(opt.Clearance+opt.TipDiameter/2))
+		outside.Z = top.Z - opt.Depth
+		err = m.moveTo(start, outside)
+		if err != nil {
+			return nil, err
+		}
+		r, err := m.probeAxis(outside, axis, -dir, opt.Clearance+math.Abs(opt.MaxTravel), opt)
+		if err != nil {
+			return nil, err
+		}
+		res.Point = setAxis(res.Point, axis, axisValue(r.Point, axis))
+		res.Spread = math.Max(res.Spread, r.Spread)
+
+		err = m.moveTo(outside, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.SetZero {
+		err = m.setZero(start, res.Point, "XYZ")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ProbeCenter will find the center of a bore, or of a boss if boss is set.
+//
+// For a bore, the probe starts inside it at the probing height. For a boss, it
+// starts above the approximate center, and probes Depth below the current position
+// from Clearance outside of Diameter.
+//
+// X is probed twice so the Y measurement, and the final X, are taken through the center.
+func (m *Machine) ProbeCenter(boss bool, opt ProbeXYOptions) (*ProbeXYResult, error) {
+	if boss {
+		if err := opt.validateOutside(true); err != nil {
+			return nil, err
+		}
+	}
+	start, err := m.idlePosition()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ProbeXYResult{Point: coord.Point{X: start.X, Y: start.Y}}
+	var diameters [2]float64
+	for i, axis := range []byte{'X', 'Y', 'X'} {
+		center := coord.Point{X: res.X, Y: res.Y, Z: start.Z}
+		var sides [2]float64
+		for n, dir := range []float64{1, -1} {
+			from := center
+			travel := math.Abs(opt.MaxTravel)
+			if boss {
+				from = setAxis(from, axis, axisValue(center, axis)+dir*(opt.Diameter/2+opt.Clearance+opt.TipDiameter/2))
+				err = m.moveTo(center, from, coord.Point{X: from.X, Y: from.Y, Z: start.Z - opt.Depth})
+				if err != nil {
+					return nil, err
+				}
+				from.Z = start.Z - opt.Depth
+				travel += opt.Clearance
+				dir = -dir
+			}
+
+			r, err := m.probeAxis(from, axis, dir, travel, opt)
+			if err != nil {
+				return nil, err
+			}
+			sides[n] = axisValue(r.Point, axis)
+			res.Spread = math.Max(res.Spread, r.Spread)
+
+			if boss {
+				err = m.moveTo(from, coord.Point{X: from.X, Y: from.Y, Z: start.Z}, center)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		res.Point = setAxis(res.Point, axis, (sides[0]+sides[1])/2)
+		diameters[i%2] = math.Abs(sides[0] - sides[1])
+		if !boss {
+			err = m.moveTo(center, coord.Point{X: res.X, Y: res.Y, Z: start.Z})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	res.Diameter = (diameters[0] + diameters[1]) / 2
+
+	// end over the center
+	if boss {
+		err = m.moveTo(start, coord.Point{X: res.X, Y: res.Y, Z: start.Z})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opt.SetZero {
+		err = m.setZero(coord.Point{X: res.X, Y: res.Y, Z: start.Z}, res.Point, "XY")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// ProbeAngle will find the rotation of a straight edge by probing it along axis in dir
+// at the current position, and again offset by spacing along the other axis.
+//
+// The angle is positive if the edge is rotated counter-clockwise. With SetZero, the
+// axis is zeroed at the first edge, as for ProbeEdge.
+func (m *Machine) ProbeAngle(axis byte, dir, spacing float64, opt ProbeXYOptions) (*ProbeXYResult, error) {
+	if axis != 'X' && axis != 'Y' {
+		return nil, errors.New("axis must be X or Y")
+	}
+	if spacing == 0 {
+		return nil, errors.New("spacing must not be zero")
+	}
+	if err := direction(&dir); err != nil {
+		return nil, err
+	}
+	start, err := m.idlePosition()
+	if err != nil {
+		return nil, err
+	}
+
+	other := byte('X')
+	if axis == 'X' {
+		other = 'Y'
+	}
+
+	first, err := m.probeAxis(start, axis, dir, math.Abs(opt.MaxTravel), opt)
+	if err != nil {
+		return nil, err
+	}
+	second := setAxis(start, other, axisValue(start, other)+spacing)
+	err = m.moveTo(start, second)
+	if err != nil {
+		return nil, err
+	}
+	r, err := m.probeAxis(second, axis, dir, math.Abs(opt.MaxTravel), opt)
+	if err != nil {
+		return nil, err
+	}
+	err = m.moveTo(second, start)
+	if err != nil {
+		return nil, err
+	}
+
+	delta := axisValue(r.Point, axis) - axisValue(first.Point, axis)
+	angle := math.Atan2(delta, spacing) * 180 / math.Pi
+	if axis == 'X' {
+		// an edge along Y leans counter-clockwise when X decreases with Y
+		angle = -angle
+	}
+
+	res := &ProbeXYResult{
+		Point:  setAxis(coord.Point{}, axis, axisValue(first.Point, axis)),
+		Angle:  angle,
+		Spread: math.Max(first.Spread, r.Spread),
+	}
+	if opt.SetZero {
+		err = m.setZero(start, res.Point, string(axis))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,43 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachine_ProbeXY_Validate(t *testing.T) {
+	valid := ProbeXYOptions{
+		ProbeOptions: ProbeOptions{FeedRate: 100, MaxTravel: 10},
+		TipDiameter:  2,
+		Clearance:    5,
+		Depth:        3,
+		Diameter:     20,
+	}
+
+	check := func(name string, fn func(m *Machine, opt ProbeXYOptions) error, update func(*ProbeXYOptions)) {
+		t.Run(name, func(t *testing.T) {
+			a := newFakeAdapter(coord.Point{Z: -5}, coord.Point{}, func(x, y float64) float64 { return -10 })
+			opt := valid
+			update(&opt)
+			assert.Error(t, fn(NewMachine(a), opt))
+			// nothing may move before the options are checked
+			assert.Empty(t, a.Lines())
+		})
+	}
+	corner := func(m *Machine, opt ProbeXYOptions) error {
+		_, err := m.ProbeCorner(1, 1, opt)
+		return err
+	}
+	boss := func(m *Machine, opt ProbeXYOptions) error {
+		_, err := m.ProbeCenter(true, opt)
+		return err
+	}
+
+	check("corner clearance", corner, func(o *ProbeXYOptions) { o.Clearance = 0 })
+	check("corner depth", corner, func(o *ProbeXYOptions) { o.Depth = -1 })
+	check("boss clearance", boss, func(o *ProbeXYOptions) { o.Clearance = 0 })
+	check("boss depth", boss, func(o *ProbeXYOptions) { o.Depth = 0 })
+	check("boss diameter", boss, func(o *ProbeXYOptions) { o.Diameter = 0 })
+}
@@ -0,0 +1,282 @@
+package machine
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+)
+
+// toolChangeReader reads lines up to the next tool change (M6), which it removes.
+//
+// Lines that are not gcode (e.g. `$` commands) are passed through as-is.
+type toolChangeReader struct {
+	scan *bufio.Scanner
+	buf  bytes.Buffer
+
+	// tool is the last tool selected with a T word.
+	tool int
+
+	// change is set once an M6 has been read.
+	change bool
+
+	// modal is the last word sent for each modal group.
+	modal             map[gcode.ModalGroup]gcode.Word
+	feed, speed       float64
+	hasFeed, hasSpeed bool
+}
+
+func newToolChangeReader(r io.Reader, tool int) *toolChangeReader {
+	return &toolChangeReader{
+		scan:  bufio.NewScanner(r),
+		tool:  tool,
+		modal: make(map[gcode.ModalGroup]gcode.Word),
+	}
+}
+
+func (r *toolChangeReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.change || !r.scan.Scan() {
+			if err := r.scan.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.buf.WriteString(r.line(r.scan.Text()))
+	}
+	return r.buf.Read(p)
+}
+
+// line will return a line to send, without any M6.
+func (r *toolChangeReader) line(s string) string {
+	b, err := gcode.NewParser(strings.NewReader(s)).Read()
+	if err != nil {
+		return s + "\n"
+	}
+	if ok, t := b.Arg('T'); ok {
+		r.tool = int(t)
+	}
+
+	var res gcode.Block
+	for _, w := range b {
+		if w.W == 'M' && w.Arg == 6 {
+			r.change = true
+			continue
+		}
+		r.record(w)
+		res = append(res, w)
+	}
+	if !r.change {
+		return s + "\n"
+	}
+	if len(res) == 0 {
+		return ""
+	}
+	return res.String() + "\n"
+}
+
+// record will track the modal state set by w.
+func (r *toolChangeReader) record(w gcode.Word) {
+	switch w.W {
+	case 'F':
+		r.feed, r.hasFeed = w.Arg, true
+		return
+	case 'S':
+		r.speed, r.hasSpeed = w.Arg, true
+		return
+	}
+	switch w.ModalGroup() {
+	case gcode.ModalGroupNone, gcode.ModalGroupNonModal, gcode.ModalGroupFeedRate,
+		gcode.ModalGroupStopping, gcode.ModalGroupToolChange, gcode.ModalGroupToolLength:
+		return
+	}
+	r.modal[w.ModalGroup()] = w
+}
+
+// prepare will return blocks to run before a tool change, stopping the spindle and
+// coolant and selecting the units and distance mode the tool change expects.
+func (r *toolChangeReader) prepare() []gcode.Block {
+	return []gcode.Block{
+		{{W: 'M', Arg: 5}, {W: 'M', Arg: 9}},
+		{{W: 'G', Arg: 21}, {W: 'G', Arg: 90}},
+	}
+}
+
+// restart will return blocks to start the spindle and coolant again after a tool change,
+// waiting delay seconds for the spindle. They are run before the tool descends back to the work.
+func (r *toolChangeReader) restart(delay float64) []gcode.Block {
+	var b []gcode.Block
+	if w, ok := r.modal[gcode.ModalGroupSpindle]; ok && w.Arg != 5 {
+		bl := gcode.Block{w}
+		if r.hasSpeed {
+			bl = append(bl, gcode.Word{W: 'S', Arg: r.speed})
+		}
+		b = append(b, bl)
+		if delay > 0 {
+			b = append(b, gcode.Block{{W: 'G', Arg: 4}, {W: 'P', Arg: delay}})
+		}
+	}
+	if w, ok := r.modal[gcode.ModalGroupCoolant]; ok && w.Arg != 9 {
+		b = append(b, gcode.Block{w})
+	}
+	return b
+}
+
+// restoreModes are the modal groups restored after a tool change, with the
+// (Grbl power-on) default used if the program has not set one.
+var restoreModes = []gcode.Word{
+	{W: 'G', Arg: 21},   // units
+	{W: 'G', Arg: 17},   // plane selection
+	{W: 'G', Arg: 90},   // distance mode
+	{W: 'G', Arg: 91.1}, // arc distance mode
+	{W: 'G', Arg: 94},   // feed rate mode
+	{W: 'G', Arg: 54},   // coordinate system
+}
+
+// restore will return blocks to return to the program's modal state after a tool change.
+//
+// The tool change may leave any mode set, so each is always sent.
+func (r *toolChangeReader) restore() []gcode.Block {
+	var modes gcode.Block
+	for _, def := range restoreModes {
+		if w, ok := r.modal[def.ModalGroup()]; ok {
+			modes = append(modes, w)
+		} else {
+			modes = append(modes, def)
+		}
+	}
+	// arcs need axis words, so only straight moves are restored
+	w, ok := r.modal[gcode.ModalGroupMotion]
+	if !ok || (w.Arg != 0 && w.Arg != 1) {
+		w = gcode.Word{W: 'G', Arg: 0}
+	}
+	modes = append(modes, w)
+	if r.hasFeed {
+		modes = append(modes, gcode.Word{W: 'F', Arg: r.feed})
+	}
+
+	return []gcode.Block{modes}
+}
+
+// manualSpindleDelay is the time, in seconds, allowed for the spindle to reach speed
+// after a tool change without a configured SpindleDelay.
+const manualSpindleDelay = 3
+
+// ReadFrom will stream a program, handling tool changes (M6).
+//
+// The spindle and coolant are stopped at each M6. If a tool change has been configured
+// with SetToolChange, it is run with the selected tool and the spindle and coolant are
+// restarted before the tool returns to the work. Otherwise, streaming pauses with a
+// prompt naming the tool to insert, and they are restarted in place once resumed.
+// Either way, the program's modal state is restored after.
+//
+// Time spent running is recorded against the current tool.
+func (m *Machine) ReadFrom(rd io.Reader) (int64, error) {
+	tools, change := m.toolConfig()
+	r := newToolChangeReader(rd, m.Tool())
+
+	// keep measurements between changes of the same job
+	var lastToolPos, referencePos *coord.Point
+	if change != nil {
+		referencePos = change.ReferencePos
+	}
+
+	var total int64
+	for {
+		r.change = false
+		start := time.Now()
+		n, err := m.Adapter.ReadFrom(r)
+		total += n
+		if err == nil {
+			// adapters stop at a read error without returning it
+			err = r.scan.Err()
+		}
+		if tools != nil && m.Tool() != 0 {
+			tools.AddUsage(m.Tool(), time.Since(start))
+		}
+		if err != nil {
+			return total, err
+		}
+		if !r.change {
+			return total, nil
+		}
+
+		err = m.runBlocks(r.prepare())
+		if err != nil {
+			return total, err
+		}
+		err = m.waitStatus(5*time.Second, "Idle")
+		if err != nil {
+			return total, err
+		}
+
+		if change == nil {
+			err = m.hold(HoldChangeTool, "Insert "+toolName(tools, r.tool)+".")
+			if err != nil {
+				return total, err
+			}
+			m.setTool(r.tool)
+			err = m.runBlocks(append(r.restart(manualSpindleDelay), r.restore()...))
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		opt := *change
+		opt.Tool = r.tool
+		opt.Tools = tools
+		opt.LastToolPos = lastToolPos
+		opt.ReferencePos = referencePos
+		opt.beforeReturn = r.restart(change.SpindleDelay)
+		res, err := m.ToolChange(opt)
+		if err != nil {
+			return total, err
+		}
+		lastToolPos = &res.ToolPos
+		referencePos = res.ReferencePos
+
+		err = m.runBlocks(r.restore())
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// SetTools will set the tool table used to describe tools and record their usage.
+func (m *Machine) SetTools(tools Tools) {
+	m.mx.Lock()
+	m.tools = tools
+	m.mx.Unlock()
+}
+
+// SetToolChange will configure the tool change run at each M6 while streaming.
+// If nil, streaming pauses with a prompt instead.
+func (m *Machine) SetToolChange(opt *ToolChangeOptions) {
+	m.mx.Lock()
+	m.toolChange = opt
+	m.mx.Unlock()
+}
+
+func (m *Machine) toolConfig() (Tools, *ToolChangeOptions) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.tools, m.toolChange
+}
+
+// Tool will return the number of the tool last changed to, or 0 if unknown.
+func (m *Machine) Tool() int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.tool
+}
+
+func (m *Machine) setTool(n int) {
+	m.mx.Lock()
+	m.tool = n
+	m.mx.Unlock()
+}
@@ -0,0 +1,161 @@
+package machine
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToolChangeReader(t *testing.T) {
+	check := func(desc, program string, tool int, exp []string, expTool int) {
+		t.Run(desc, func(t *testing.T) {
+			r := newToolChangeReader(strings.NewReader(program), tool)
+			var out []string
+			// each read stops at an M6, and continues after change is cleared
+			for range exp {
+				r.change = false
+				data, err := ioutil.ReadAll(r)
+				assert.NoError(t, err)
+				out = append(out, string(data))
+			}
+			assert.Equal(t, exp, out)
+			assert.Equal(t, expTool, r.tool)
+		})
+	}
+
+	check("no change", "G0 X1\nG1 X2 F100\n", 1,
+		[]string{"G0 X1\nG1 X2 F100\n"}, 1)
+	check("change", "T2\nG0 X1\nT3 M6\nG0 X2\n", 1,
+		[]string{"T2\nG0 X1\nT3\n", "G0 X2\n"}, 3)
+	check("change alone", "G0 X1\nM6\nG0 X2\n", 4,
+		[]string{"G0 X1\n", "G0 X2\n"}, 4)
+	check("multiple", "T1 M6\nG0 X1\nT2 M6\n", 0,
+		[]string{"T1\n", "G0 X1\nT2\n", ""}, 2)
+	check("raw lines", "$H\nT5 M6\n$X\n", 0,
+		[]string{"$H\nT5\n", "$X\n"}, 5)
+}
+
+func TestToolChangeReader_Restore(t *testing.T) {
+	blocks := func(b []gcode.Block) []string {
+		var res []string
+		for _, bl := range b {
+			res = append(res, bl.String())
+		}
+		return res
+	}
+	check := func(desc, program string, delay float64, restart, restore []string) {
+		t.Run(desc, func(t *testing.T) {
+			r := newToolChangeReader(strings.NewReader(program), 0)
+			_, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.True(t, r.change)
+
+			assert.Equal(t, []string{"M5M9", "G21G90"}, blocks(r.prepare()))
+			assert.Equal(t, restart, blocks(r.restart(delay)))
+			assert.Equal(t, restore, blocks(r.restore()))
+		})
+	}
+
+	check("spindle on", "G20 G91\nM3 S1000\nM8\nG1 X1 F20\nT2 M6\n", 2,
+		[]string{"M3S1000", "G4P2", "M8"},
+		[]string{"G20G17G91G91.1G94G54G1F20"})
+	check("no delay", "M4 S500\nT2 M6\n", 0,
+		[]string{"M4S500"},
+		[]string{"G21G17G90G91.1G94G54G0"})
+	check("spindle off", "M3 S1000\nG0 X1\nM5 M9\nT2 M6\n", 2,
+		nil,
+		[]string{"G21G17G90G91.1G94G54G0"})
+	check("arc", "G18 G55 G2 X1 Z1 I1 K0 F10\nT2 M6\n", 2,
+		nil,
+		[]string{"G21G18G90G91.1G94G55G0F10"})
+}
+
+func TestMachine_ReadFrom_ToolChange(t *testing.T) {
+	a := newFakeAdapter(coord.Point{Z: -5}, coord.Point{}, func(x, y float64) float64 { return -15 })
+	m := NewMachine(a)
+	go func() {
+		for h := range m.HoldMessage() {
+			if !h.Done {
+				m.ResolveHold(h.ID, "")
+			}
+		}
+	}()
+	m.SetToolChange(&ToolChangeOptions{
+		ChangePos:    coord.Point{X: -10, Y: -10, Z: -2},
+		ProbePos:     coord.Point{X: -20, Y: -20, Z: -2},
+		FeedRate:     100,
+		MaxTravel:    -20,
+		TravelHeight: -1,
+		SpindleDelay: 2,
+	})
+
+	_, err := m.ReadFrom(strings.NewReader("M3 S1000\nG0 X1 Y1\nG1 Z-3 F100\nT2 M6\nG1 X2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, m.Tool())
+
+	lines := a.Lines()
+	index := func(s string, after int) int {
+		for i := after + 1; i < len(lines); i++ {
+			if lines[i] == s {
+				return i
+			}
+		}
+		return -1
+	}
+	change := index("T2", -1)
+	over := index("G53G0X1Y1", change)
+	start := index("M3S1000", change)
+	dwell := index("G4P2", start)
+	descend := index("G53G0Z-3", over)
+	assert.True(t, change >= 0 && over > change, "returns over the work")
+	assert.True(t, start > over && dwell > start, "restarts the spindle over the work")
+	assert.True(t, descend > dwell, "descends after the spindle is started: %v", lines)
+	assert.Equal(t, "G1 X2", lines[len(lines)-1])
+
+	// the tool change probes in G91, the program continues in G90
+	assert.False(t, a.relative)
+	assert.Equal(t, 2.0, a.CurrentState().MPos.X)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("read failed") }
+
+func TestMachine_ReadFrom_Error(t *testing.T) {
+	a := newFakeAdapter(coord.Point{}, coord.Point{}, nil)
+	m := NewMachine(a)
+
+	_, err := m.ReadFrom(io.MultiReader(strings.NewReader("G0 X1\n"), errReader{}))
+	assert.EqualError(t, err, "read failed")
+	assert.Equal(t, []string{"G0 X1"}, a.Lines())
+}
+
+func TestMachine_ReadFrom_ManualToolChange(t *testing.T) {
+	a := newFakeAdapter(coord.Point{Z: -5}, coord.Point{}, nil)
+	m := NewMachine(a)
+	go func() {
+		for h := range m.HoldMessage() {
+			if !h.Done {
+				// the spindle must be stopped before the tool is changed
+				assert.Equal(t, []string{"M5M9", "G21G90"}, a.Lines()[4:6])
+				m.ResolveHold(h.ID, "")
+			}
+		}
+	}()
+
+	_, err := m.ReadFrom(strings.NewReader("M3 S1000\nM8\nG1 X1 F100\nT2 M6\nG1 X2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, m.Tool())
+	assert.Equal(t, []string{
+		"M3 S1000", "M8", "G1 X1 F100", "T2",
+		"M5M9", "G21G90", "M0",
+		"M3S1000", "G4P3", "M8", "G21G17G90G91.1G94G54G1F100",
+		"G1 X2",
+	}, a.Lines())
+}
@@ -16,60 +16,185 @@ type ToolChangeOptions struct {
 	MaxTravel    float64
 	TravelHeight float64
 
+	// Tool is the number of the new tool, used in prompts and recorded as the current tool.
+	Tool int
+
+	// Tools, if set, is used to describe the new tool.
+	Tools Tools `json:"-"`
+
+	// ProbeCommand is the probe command to use with the tool setter, see ProbeOptions.Command.
+	ProbeCommand float64
+
 	LastToolPos *coord.Point
+
+	// UseTLO will apply the length of the new tool as a tool length offset (G43.1)
+	// relative to ReferencePos, instead of shifting work Z with G92.
+	UseTLO bool
+
+	// ReferencePos is the machine position of the reference tool on the tool setter,
+	// used in TLO mode and to record tool lengths in Tools.
+	// If unset in TLO mode, the current tool is measured and used, less the active offset.
+	ReferencePos *coord.Point `json:",omitempty"`
+
+	// SpindleDelay is how long, in seconds, to wait for the spindle to start
+	// before returning to a program after a tool change at M6.
+	SpindleDelay float64
+
+	// beforeReturn are blocks to run above the starting position, before descending to it.
+	beforeReturn []gcode.Block
+}
+
+// ToolChangeResult is the outcome of a tool change.
+type ToolChangeResult struct {
+	// Tool is the number of the new tool, if known.
+	Tool int `json:",omitempty"`
+
+	// ToolPos is the machine position of the new tool on the tool setter,
+	// usable as LastToolPos for the next change.
+	ToolPos coord.Point
+
+	// ReferencePos is the reference tool position, if known.
+	ReferencePos *coord.Point `json:",omitempty"`
+
+	// TLO is the tool length offset applied in TLO mode.
+	TLO float64
 }
 
 // ToolChange will immediatly start a tool change operation.
-func (m *Machine) ToolChange(opt ToolChangeOptions) error {
+func (m *Machine) ToolChange(opt ToolChangeOptions) (*ToolChangeResult, error) {
 	stat := m.CurrentState()
 	if stat.Status != "Idle" {
-		return errors.New("machine not idle")
+		return nil, errors.New("machine not idle")
 	}
 
-	if opt.LastToolPos == nil {
+	if opt.UseTLO && opt.ReferencePos == nil {
+		ref, err := m.measureReference(opt, stat.TLO)
+		if err != nil {
+			return nil, err
+		}
+		opt.ReferencePos = ref
+		err = m.hold(HoldRemoveProbe, "Probe complete, remove Z-Probe.")
+		if err != nil {
+			return nil, err
+		}
+	} else if !opt.UseTLO && opt.LastToolPos == nil {
 		// get current tool first
 		p, err := m.toolProbe(opt, false, 0)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		opt.LastToolPos = &p.Point
-		err = m.hold("Probe complete, remove Z-Probe.")
+		err = m.hold(HoldRemoveProbe, "Probe complete, remove Z-Probe.")
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	err := m.runBlocks(generateGoTo(opt.TravelHeight, opt.ChangePos))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	m.hold("Perform tool change.")
+	prompt := "Perform tool change."
+	if opt.Tool != 0 {
+		prompt = "Insert " + toolName(opt.Tools, opt.Tool) + "."
+	}
+	err = m.hold(HoldChangeTool, prompt)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	m.setTool(opt.Tool)
 
-	lastToolWPos := opt.LastToolPos.Sub(stat.WCO)
-	p, err := m.toolProbe(opt, true, lastToolWPos.Z)
-	if err != nil {
-		return err
+	res := ToolChangeResult{Tool: opt.Tool}
+	if opt.UseTLO {
+		p, err := m.toolProbe(opt, false, 0)
+		if err != nil {
+			return nil, err
+		}
+		res.ToolPos = p.Point
+		res.TLO = p.Z - opt.ReferencePos.Z
+		err = m.SetTLO(res.TLO)
+		if err != nil {
+			return nil, err
+		}
+		stat.MPos.Z += res.TLO - stat.TLO
+		log.Println("Setting tool length offset to:", res.TLO)
+	} else {
+		lastToolWPos := opt.LastToolPos.Sub(stat.WCO)
+		p, err := m.toolProbe(opt, true, lastToolWPos.Z)
+		if err != nil {
+			return nil, err
+		}
+		res.ToolPos = p.Point
+
+		diff := opt.LastToolPos.Z - p.Z
+		stat.MPos.Z -= diff
+		log.Println("Adjusting Z-offset by:", diff)
 	}
 
-	diff := opt.LastToolPos.Z - p.Z
-	stat.MPos.Z -= diff
-	log.Println("Adjusting Z-offset by:", diff)
+	res.ReferencePos = opt.ReferencePos
+	if opt.Tools != nil && opt.Tool != 0 && opt.ReferencePos != nil {
+		opt.Tools.RecordLength(opt.Tool, res.ToolPos.Z-opt.ReferencePos.Z)
+	}
 
-	m.hold("Probe complete, remove Z-Probe.")
+	err = m.hold(HoldRemoveProbe, "Probe complete, remove Z-Probe.")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	back := generateGoTo(opt.TravelHeight, stat.MPos)
+	err = m.runBlocks(append(append(back[:2:2], opt.beforeReturn...), back[2]))
+	if err != nil {
+		return nil, err
 	}
 
+	return &res, nil
+}
+
+// MeasureReference will measure the current tool on the tool setter, returning the
+// position the reference tool (with no offset) would have.
+func (m *Machine) MeasureReference(opt ToolChangeOptions) (*coord.Point, error) {
+	stat := m.CurrentState()
+	if stat.Status != "Idle" {
+		return nil, errors.New("machine not idle")
+	}
+	ref, err := m.measureReference(opt, stat.TLO)
+	if err != nil {
+		return nil, err
+	}
+	err = m.hold(HoldRemoveProbe, "Probe complete, remove Z-Probe.")
+	if err != nil {
+		return nil, err
+	}
 	err = m.runBlocks(generateGoTo(opt.TravelHeight, stat.MPos))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return ref, nil
+}
 
-	return nil
+func (m *Machine) measureReference(opt ToolChangeOptions, tlo float64) (*coord.Point, error) {
+	p, err := m.toolProbe(opt, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	ref := p.Point
+	ref.Z -= tlo
+	return &ref, nil
+}
+
+// SetTLO will apply a tool length offset with G43.1, and request the
+// parameters so it is reflected in the machine state.
+func (m *Machine) SetTLO(z float64) error {
+	err := m.runBlocks([]gcode.Block{{
+		{W: 'G', Arg: 43.1},
+		{W: 'Z', Arg: z},
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = m.Adapter.Write([]byte("$#\n"))
+	return err
 }
 
 func (m *Machine) toolProbe(opt ToolChangeOptions, zero bool, offset float64) (*ProbeResult, error) {
@@ -84,6 +209,7 @@ func (m *Machine) toolProbe(opt ToolChangeOptions, zero bool, offset float64) (*
 		FeedRate:  opt.FeedRate,
 		ZeroZAxis: zero,
 		Offset:    offset,
+		Command:   opt.ProbeCommand,
 	})
 	if err != nil {
 		return nil, err
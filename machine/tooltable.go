@@ -0,0 +1,169 @@
+package machine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ToolType is the cutting profile of a tool.
+type ToolType string
+
+// Supported tool types.
+const (
+	ToolFlat ToolType = "flat"
+	ToolBall ToolType = "ball"
+	ToolV    ToolType = "v"
+)
+
+// Tool is an entry in the tool table.
+type Tool struct {
+	Number      int
+	Description string
+	Diameter    float64
+	Type        ToolType
+	Flutes      int
+
+	// Length is the length of the tool relative to the reference tool, as measured
+	// on the tool setter (i.e. the tool length offset to use).
+	Length *float64 `json:",omitempty"`
+
+	// Measured is when Length was last recorded.
+	Measured *time.Time `json:",omitempty"`
+
+	// UsageTime is the total time, in seconds, spent running programs with the tool.
+	UsageTime float64
+}
+
+// Validate will check that a tool has a number and known type.
+func (t Tool) Validate() error {
+	if t.Number < 1 {
+		return errors.New("tool number must be positive")
+	}
+	switch t.Type {
+	case "", ToolFlat, ToolBall, ToolV:
+	default:
+		return errors.New("unknown tool type: " + string(t.Type))
+	}
+	if t.Diameter < 0 {
+		return errors.New("tool diameter must not be negative")
+	}
+	if t.Flutes < 0 {
+		return errors.New("flute count must not be negative")
+	}
+	return nil
+}
+
+// String will describe the tool for prompts, e.g. "T3 1/8in endmill (3.175mm flat, 2 flutes)".
+func (t Tool) String() string {
+	name := "T" + strconv.Itoa(t.Number)
+	if t.Description != "" {
+		name += " " + t.Description
+	}
+
+	var details []string
+	if t.Diameter > 0 {
+		d := strconv.FormatFloat(t.Diameter, 'f', -1, 64) + "mm"
+		if t.Type != "" {
+			d += " " + string(t.Type)
+		}
+		details = append(details, d)
+	} else if t.Type != "" {
+		details = append(details, string(t.Type))
+	}
+	if t.Flutes == 1 {
+		details = append(details, "1 flute")
+	} else if t.Flutes > 1 {
+		details = append(details, fmt.Sprintf("%d flutes", t.Flutes))
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return name + " (" + strings.Join(details, ", ") + ")"
+}
+
+// ToolTable is a list of tools, sorted by number.
+type ToolTable []Tool
+
+// ReadToolTable will read a tool table in JSON format.
+func ReadToolTable(r io.Reader) (ToolTable, error) {
+	var t ToolTable
+	err := json.NewDecoder(r).Decode(&t)
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range t {
+		err = tool.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.sort()
+	return t, nil
+}
+
+func (t ToolTable) sort() {
+	sort.Slice(t, func(i, j int) bool { return t[i].Number < t[j].Number })
+}
+
+// Tool will return the tool with number n.
+func (t ToolTable) Tool(n int) (*Tool, bool) {
+	for i := range t {
+		if t[i].Number == n {
+			return &t[i], true
+		}
+	}
+	return nil, false
+}
+
+// Set will add a tool, replacing any with the same number.
+func (t ToolTable) Set(tool Tool) (ToolTable, error) {
+	err := tool.Validate()
+	if err != nil {
+		return t, err
+	}
+	if old, ok := t.Tool(tool.Number); ok {
+		*old = tool
+		return t, nil
+	}
+	t = append(t, tool)
+	t.sort()
+	return t, nil
+}
+
+// Delete will remove the tool with number n.
+func (t ToolTable) Delete(n int) (ToolTable, bool) {
+	for i := range t {
+		if t[i].Number == n {
+			return append(t[:i], t[i+1:]...), true
+		}
+	}
+	return t, false
+}
+
+// Tools provide tool information while running programs.
+type Tools interface {
+	// Tool will return the tool with number n, if known.
+	Tool(n int) (*Tool, bool)
+
+	// AddUsage will record time spent running with tool n.
+	AddUsage(n int, d time.Duration)
+
+	// RecordLength will record the measured length of tool n, relative to the reference tool.
+	RecordLength(n int, length float64)
+}
+
+// toolName will describe tool n for prompts.
+func toolName(tools Tools, n int) string {
+	if tools != nil {
+		if t, ok := tools.Tool(n); ok {
+			return t.String()
+		}
+	}
+	return "T" + strconv.Itoa(n)
+}
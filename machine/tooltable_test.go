@@ -0,0 +1,74 @@
+package machine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTool_Validate(t *testing.T) {
+	check := func(tool Tool, valid bool) {
+		t.Helper()
+		err := tool.Validate()
+		if valid {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+
+	check(Tool{Number: 1}, true)
+	check(Tool{Number: 2, Type: ToolV, Diameter: 6, Flutes: 1}, true)
+	check(Tool{Number: 0}, false)
+	check(Tool{Number: -1}, false)
+	check(Tool{Number: 1, Type: "drill"}, false)
+	check(Tool{Number: 1, Diameter: -1}, false)
+	check(Tool{Number: 1, Flutes: -2}, false)
+}
+
+func TestTool_String(t *testing.T) {
+	check := func(tool Tool, exp string) {
+		t.Helper()
+		assert.Equal(t, exp, tool.String())
+	}
+
+	check(Tool{Number: 3}, "T3")
+	check(Tool{Number: 3, Description: "1/8in endmill", Diameter: 3.175, Type: ToolFlat, Flutes: 2}, "T3 1/8in endmill (3.175mm flat, 2 flutes)")
+	check(Tool{Number: 4, Type: ToolBall}, "T4 (ball)")
+	check(Tool{Number: 5, Diameter: 1, Flutes: 1}, "T5 (1mm, 1 flute)")
+}
+
+func TestToolTable(t *testing.T) {
+	tt, err := ReadToolTable(strings.NewReader(`[{"Number":3},{"Number":1,"Description":"a"}]`))
+	assert.NoError(t, err)
+	assert.Len(t, tt, 2)
+	assert.Equal(t, 1, tt[0].Number)
+
+	_, err = ReadToolTable(strings.NewReader(`[{"Number":0}]`))
+	assert.Error(t, err)
+
+	tt, err = tt.Set(Tool{Number: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, []int{tt[0].Number, tt[1].Number, tt[2].Number})
+
+	// replace by number
+	tt, err = tt.Set(Tool{Number: 1, Description: "b"})
+	assert.NoError(t, err)
+	assert.Len(t, tt, 3)
+	tool, ok := tt.Tool(1)
+	assert.True(t, ok)
+	assert.Equal(t, "b", tool.Description)
+
+	_, err = tt.Set(Tool{Number: 4, Type: "drill"})
+	assert.Error(t, err)
+
+	tt, ok = tt.Delete(2)
+	assert.True(t, ok)
+	assert.Len(t, tt, 2)
+	_, ok = tt.Tool(2)
+	assert.False(t, ok)
+
+	_, ok = tt.Delete(9)
+	assert.False(t, ok)
+}
@@ -0,0 +1,297 @@
+package meshlevel
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// AnalyzeOptions configure probe point analysis.
+type AnalyzeOptions struct {
+	// Threshold is the max distance a point may deviate from the surface
+	// predicted by its neighbors before it is considered an outlier. Defaults to 0.1.
+	Threshold float64
+
+	// Neighbors is the number of nearby points used to predict each point. Defaults to 8.
+	Neighbors int
+}
+
+// An Outlier is a probe point that should be excluded or re-probed.
+type Outlier struct {
+	// Index is the position of the point in the original set.
+	Index int
+	Point coord.Point
+
+	// Invalid is set if the probe did not make contact.
+	Invalid bool
+
+	// Deviation is how far the point is above (positive) or below the surface predicted
+	// by its neighbors.
+	Deviation float64
+}
+
+// Quality describes a set of probe points.
+type Quality struct {
+	// Count is the number of points used, excluding outliers.
+	Count int
+
+	MinZ, MaxZ float64
+
+	// Plane is the least-squares fit of the points.
+	Plane Plane
+
+	// PlaneRMS is the RMS distance of the points from Plane.
+	PlaneRMS float64
+
+	// Flatness is the distance between the highest and lowest point relative to Plane.
+	Flatness float64
+
+	Outliers []Outlier
+}
+
+// pointIndex is a uniform grid of point indexes, like the Mesh index, used to find
+// the nearest neighbors of a point without checking all of them.
+type pointIndex struct {
+	points     []coord.Point
+	minX, minY float64
+	cellSize   float64
+	cols, rows int
+	cells      [][]int
+}
+
+// newPointIndex will index the points listed in idx, sizing cells so that each holds
+// roughly one point.
+func newPointIndex(points []coord.Point, idx []int) *pointIndex {
+	pi := &pointIndex{points: points, minX: math.Inf(1), minY: math.Inf(1)}
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, i := range idx {
+		pi.minX = math.Min(pi.minX, points[i].X)
+		pi.minY = math.Min(pi.minY, points[i].Y)
+		maxX = math.Max(maxX, points[i].X)
+		maxY = math.Max(maxY, points[i].Y)
+	}
+	w, h := maxX-pi.minX, maxY-pi.minY
+	pi.cellSize = math.Sqrt(w * h / float64(len(idx)))
+	if pi.cellSize <= 0 {
+		// points are in a line
+		pi.cellSize = math.Max(w, h) / float64(len(idx))
+	}
+	if pi.cellSize <= 0 {
+		pi.cellSize = 1
+	}
+	pi.cols = int(w/pi.cellSize) + 1
+	pi.rows = int(h/pi.cellSize) + 1
+	pi.cells = make([][]int, pi.cols*pi.rows)
+	for _, i := range idx {
+		c, r := pi.cell(points[i].X, points[i].Y)
+		pi.cells[r*pi.cols+c] = append(pi.cells[r*pi.cols+c], i)
+	}
+	return pi
+}
+
+// cell will return the index cell for x,y, clamped to the grid.
+func (pi *pointIndex) cell(x, y float64) (int, int) {
+	c := int((x - pi.minX) / pi.cellSize)
+	r := int((y - pi.minY) / pi.cellSize)
+	if c < 0 {
+		c = 0
+	} else if c >= pi.cols {
+		c = pi.cols - 1
+	}
+	if r < 0 {
+		r = 0
+	} else if r >= pi.rows {
+		r = pi.rows - 1
+	}
+	return c, r
+}
+
+// remove will drop point i from the index.
+func (pi *pointIndex) remove(i int) {
+	c, r := pi.cell(pi.points[i].X, pi.points[i].Y)
+	cell := pi.cells[r*pi.cols+c]
+	for n, j := range cell {
+		if j == i {
+			pi.cells[r*pi.cols+c] = append(cell[:n], cell[n+1:]...)
+			return
+		}
+	}
+}
+
+// nearest will return the n points closest to point i, not including itself.
+//
+// Cells are searched in rings around the point until no closer point can remain.
+func (pi *pointIndex) nearest(i, n int) []int {
+	p := pi.points[i]
+	dist := func(j int) float64 { return pi.points[j].DistanceXY(p.X, p.Y) }
+	less := func(a, b int) bool {
+		da, db := dist(a), dist(b)
+		if da == db {
+			return a < b
+		}
+		return da < db
+	}
+
+	c0, r0 := pi.cell(p.X, p.Y)
+	var near []int
+	for ring := 0; ring <= pi.cols || ring <= pi.rows; ring++ {
+		for r := r0 - ring; r <= r0+ring; r++ {
+			for c := c0 - ring; c <= c0+ring; c++ {
+				if r < 0 || r >= pi.rows || c < 0 || c >= pi.cols {
+					continue
+				}
+				if r != r0-ring && r != r0+ring && c != c0-ring && c != c0+ring {
+					// inside the ring, already checked
+					continue
+				}
+				for _, j := range pi.cells[r*pi.cols+c] {
+					if j != i {
+						near = append(near, j)
+					}
+				}
+			}
+		}
+		if len(near) < n {
+			continue
+		}
+		sort.Slice(near, func(a, b int) bool { return less(near[a], near[b]) })
+		near = near[:n]
+		// anything in the next ring is at least this far away
+		if dist(near[n-1]) <= float64(ring)*pi.cellSize {
+			break
+		}
+	}
+	return near
+}
+
+// predict will estimate the Z value at p from its neighbors.
+func predict(p coord.Point, near []coord.Point) float64 {
+	plane, err := FitPlane(near)
+	if err == nil {
+		_, z := plane.OffsetZ(p.X, p.Y)
+		return z
+	}
+
+	// neighbors are all in a line, use the average
+	var sum float64
+	for _, np := range near {
+		sum += np.Z
+	}
+	return sum / float64(len(near))
+}
+
+// minAnalyzePoints is the fewest valid points outliers can be checked with,
+// each is predicted by a plane through at least 3 others.
+const minAnalyzePoints = 4
+
+// Analyze will check a set of probe points for outliers and report the flatness of the rest.
+//
+// If valid is not nil, points with a false value are reported as outliers without being considered.
+// Outliers are removed one at a time, worst first, so a single bad point does not cause its
+// neighbors to be flagged. With Neighbors or fewer valid points, all of the others are used
+// to predict each point; an error is returned if there are too few to check.
+func Analyze(points []coord.Point, valid []bool, opt AnalyzeOptions) (*Quality, error) {
+	if valid != nil && len(valid) != len(points) {
+		return nil, errors.New("valid must be the same length as points")
+	}
+	if opt.Threshold == 0 {
+		opt.Threshold = 0.1
+	}
+	if opt.Neighbors == 0 {
+		opt.Neighbors = 8
+	}
+
+	var q Quality
+	var idx []int
+	for i, p := range points {
+		if valid != nil && !valid[i] {
+			q.Outliers = append(q.Outliers, Outlier{Index: i, Point: p, Invalid: true})
+			continue
+		}
+		idx = append(idx, i)
+	}
+	if len(idx) < minAnalyzePoints {
+		return nil, errors.New("need at least 4 valid points to check for outliers")
+	}
+
+	// neighbors are found once, then updated only for points that lose one
+	index := newPointIndex(points, idx)
+	near := make([][]int, len(points))
+	users := make([][]int, len(points))
+	dev := make([]float64, len(points))
+	update := func(i int) {
+		n := opt.Neighbors
+		if n > len(idx)-1 {
+			n = len(idx) - 1
+		}
+		near[i] = index.nearest(i, n)
+		np := make([]coord.Point, len(near[i]))
+		for k, j := range near[i] {
+			np[k] = points[j]
+			users[j] = append(users[j], i)
+		}
+		dev[i] = points[i].Z - predict(points[i], np)
+	}
+	for _, i := range idx {
+		update(i)
+	}
+
+	for len(idx) >= minAnalyzePoints {
+		worst := -1
+		for n, i := range idx {
+			if worst == -1 || math.Abs(dev[i]) > math.Abs(dev[idx[worst]]) {
+				worst = n
+			}
+		}
+		i := idx[worst]
+		if math.Abs(dev[i]) <= opt.Threshold {
+			break
+		}
+		q.Outliers = append(q.Outliers, Outlier{Index: i, Point: points[i], Deviation: dev[i]})
+		idx = append(idx[:worst], idx[worst+1:]...)
+		index.remove(i)
+		near[i] = nil
+
+		for _, j := range users[i] {
+			for _, k := range near[j] {
+				if k == i {
+					update(j)
+					break
+				}
+			}
+		}
+		users[i] = nil
+	}
+	sort.Slice(q.Outliers, func(i, j int) bool { return q.Outliers[i].Index < q.Outliers[j].Index })
+
+	good := make([]coord.Point, len(idx))
+	for n, i := range idx {
+		good[n] = points[i]
+	}
+	plane, err := FitPlane(good)
+	if err != nil {
+		return nil, err
+	}
+
+	q.Count = len(good)
+	q.Plane = *plane
+	q.MinZ, q.MaxZ = math.Inf(1), math.Inf(-1)
+	lo, hi := math.Inf(1), math.Inf(-1)
+	var sq float64
+	for _, p := range good {
+		q.MinZ = math.Min(q.MinZ, p.Z)
+		q.MaxZ = math.Max(q.MaxZ, p.Z)
+
+		_, z := plane.OffsetZ(p.X, p.Y)
+		r := p.Z - z
+		lo = math.Min(lo, r)
+		hi = math.Max(hi, r)
+		sq += r * r
+	}
+	q.PlaneRMS = math.Sqrt(sq / float64(len(good)))
+	q.Flatness = hi - lo
+
+	return &q, nil
+}
@@ -0,0 +1,79 @@
+package meshlevel
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyze(t *testing.T) {
+	var points []coord.Point
+	for y := 0.0; y <= 40; y += 10 {
+		for x := 0.0; x <= 40; x += 10 {
+			points = append(points, coord.Point{X: x, Y: y, Z: 0.01*x + 0.02*y})
+		}
+	}
+	// chip under the probe
+	points[7].Z += 0.5
+	// failed touch
+	valid := make([]bool, len(points))
+	for i := range valid {
+		valid[i] = i != 12
+	}
+
+	q, err := Analyze(points, valid, AnalyzeOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, q.Outliers, 2) {
+		assert.Equal(t, 7, q.Outliers[0].Index)
+		assert.False(t, q.Outliers[0].Invalid)
+		assert.InDelta(t, 0.5, q.Outliers[0].Deviation, 0.000001)
+		assert.Equal(t, 12, q.Outliers[1].Index)
+		assert.True(t, q.Outliers[1].Invalid)
+	}
+
+	assert.Equal(t, 23, q.Count)
+	assert.InDelta(t, 0, q.MinZ, 0.000001)
+	assert.InDelta(t, 1.2, q.MaxZ, 0.000001)
+	assert.InDelta(t, 0.01, q.Plane.A, 0.000001)
+	assert.InDelta(t, 0.02, q.Plane.B, 0.000001)
+	assert.InDelta(t, 0, q.PlaneRMS, 0.000001)
+	assert.InDelta(t, 0, q.Flatness, 0.000001)
+}
+
+func TestAnalyze_Small(t *testing.T) {
+	// corners and center, fewer than the default 8 neighbors
+	points := []coord.Point{
+		{X: 0, Y: 0, Z: 0},
+		{X: 10, Y: 0, Z: 0.1},
+		{X: 0, Y: 10, Z: 0.2},
+		{X: 10, Y: 10, Z: 0.3},
+		{X: 5, Y: 5, Z: 0.65},
+	}
+	q, err := Analyze(points, nil, AnalyzeOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, q.Outliers, 1) {
+		assert.Equal(t, 4, q.Outliers[0].Index)
+		assert.InDelta(t, 0.5, q.Outliers[0].Deviation, 0.000001)
+	}
+	assert.Equal(t, 4, q.Count)
+
+	_, err = Analyze(points, []bool{true, true, false, false, true}, AnalyzeOptions{})
+	assert.Error(t, err)
+}
+
+func BenchmarkAnalyze_50x50(b *testing.B) {
+	var points []coord.Point
+	for y := 0; y < 50; y++ {
+		for x := 0; x < 50; x++ {
+			points = append(points, coord.Point{X: float64(x), Y: float64(y), Z: 0.01 * float64(x)})
+		}
+	}
+	for i := 0; i < len(points); i += 97 {
+		points[i].Z += 0.5
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Analyze(points, nil, AnalyzeOptions{})
+	}
+}
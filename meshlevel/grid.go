@@ -0,0 +1,204 @@
+package meshlevel
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// GridTolerance is the max distance between probe coordinates that are
+// considered to be on the same row or column of a Grid.
+const GridTolerance = 0.01
+
+// Grid will interpolate over a regular (rectangular, but not necessarily evenly spaced)
+// grid of points, such as those from machine.ProbeZGrid.
+type Grid struct {
+	xs, ys []float64
+
+	// z is indexed by row (y), then column (x)
+	z [][]float64
+
+	cubic bool
+}
+
+// axisValues will group values within GridTolerance of each other,
+// returning the average of each group in ascending order.
+func axisValues(v []float64) []float64 {
+	v = append([]float64(nil), v...)
+	sort.Float64s(v)
+
+	var res []float64
+	var sum float64
+	var n int
+	for i, val := range v {
+		if n > 0 && val-v[i-1] > GridTolerance {
+			res = append(res, sum/float64(n))
+			sum, n = 0, 0
+		}
+		sum += val
+		n++
+	}
+	return append(res, sum/float64(n))
+}
+
+// axisIndex will return the index in vals of the value within GridTolerance of v.
+func axisIndex(vals []float64, v float64) int {
+	i := sort.SearchFloat64s(vals, v-GridTolerance)
+	if i < len(vals) && math.Abs(vals[i]-v) <= GridTolerance {
+		return i
+	}
+	return -1
+}
+
+// NewGrid will create a Grid from a set of probe points. Duplicate points are averaged.
+//
+// If cubic is true, points are interpolated with a bicubic spline, otherwise bilinear.
+func NewGrid(points []coord.Point, cubic bool) (*Grid, error) {
+	if len(points) < 4 {
+		return nil, errors.New("need at least 4 points to create a grid")
+	}
+
+	xv := make([]float64, len(points))
+	yv := make([]float64, len(points))
+	for i, p := range points {
+		xv[i] = p.X
+		yv[i] = p.Y
+	}
+
+	g := &Grid{xs: axisValues(xv), ys: axisValues(yv), cubic: cubic}
+	if len(g.xs) < 2 || len(g.ys) < 2 {
+		return nil, errors.New("points do not form a grid")
+	}
+
+	g.z = make([][]float64, len(g.ys))
+	count := make([][]int, len(g.ys))
+	for r := range g.z {
+		g.z[r] = make([]float64, len(g.xs))
+		count[r] = make([]int, len(g.xs))
+	}
+	for _, p := range points {
+		r, c := axisIndex(g.ys, p.Y), axisIndex(g.xs, p.X)
+		if r == -1 || c == -1 {
+			// values of a group can span more than the tolerance from its average
+			return nil, errors.New("points do not form a regular grid")
+		}
+		g.z[r][c] += p.Z
+		count[r][c]++
+	}
+	for r := range g.z {
+		for c := range g.z[r] {
+			if count[r][c] == 0 {
+				return nil, errors.New("points do not form a regular grid")
+			}
+			g.z[r][c] /= float64(count[r][c])
+		}
+	}
+
+	return g, nil
+}
+
+// span will return the index of the interval in vals containing v, clamped to the ends.
+func span(vals []float64, v float64) int {
+	i := sort.SearchFloat64s(vals, v) - 1
+	if i < 0 {
+		return 0
+	}
+	if i > len(vals)-2 {
+		return len(vals) - 2
+	}
+	return i
+}
+
+// hermite will interpolate between val(i) and val(i+1) at v using a cubic
+// Hermite spline, with tangents from finite differences of the neighboring points.
+func hermite(pos []float64, val func(int) float64, i int, v float64) float64 {
+	tangent := func(k int) float64 {
+		lo, hi := k-1, k+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(pos)-1 {
+			hi = len(pos) - 1
+		}
+		return (val(hi) - val(lo)) / (pos[hi] - pos[lo])
+	}
+
+	h := pos[i+1] - pos[i]
+	t := (v - pos[i]) / h
+	t2, t3 := t*t, t*t*t
+	return (2*t3-3*t2+1)*val(i) +
+		(t3-2*t2+t)*h*tangent(i) +
+		(-2*t3+3*t2)*val(i+1) +
+		(t3-t2)*h*tangent(i+1)
+}
+
+// bilinear will interpolate within the cell at (c, r). Points outside
+// of the cell are extrapolated linearly.
+func (g *Grid) bilinear(c, r int, x, y float64) float64 {
+	tx := (x - g.xs[c]) / (g.xs[c+1] - g.xs[c])
+	ty := (y - g.ys[r]) / (g.ys[r+1] - g.ys[r])
+
+	z0 := g.z[r][c] + (g.z[r][c+1]-g.z[r][c])*tx
+	z1 := g.z[r+1][c] + (g.z[r+1][c+1]-g.z[r+1][c])*tx
+	return z0 + (z1-z0)*ty
+}
+
+func (g *Grid) at(x, y float64) float64 {
+	c, r := span(g.xs, x), span(g.ys, y)
+	if !g.cubic {
+		return g.bilinear(c, r, x, y)
+	}
+
+	row := func(r int) float64 {
+		return hermite(g.xs, func(c int) float64 { return g.z[r][c] }, c, x)
+	}
+	return hermite(g.ys, row, r, y)
+}
+
+func (g *Grid) contains(x, y float64) bool {
+	return x >= g.xs[0]-coord.Epsilon && x <= g.xs[len(g.xs)-1]+coord.Epsilon &&
+		y >= g.ys[0]-coord.Epsilon && y <= g.ys[len(g.ys)-1]+coord.Epsilon
+}
+
+// OffsetZ will return the interpolated offset at (x, y), or false if it is outside of the grid.
+func (g *Grid) OffsetZ(x, y float64) (bool, float64) {
+	if !g.contains(x, y) {
+		return false, 0
+	}
+	return true, g.at(x, y)
+}
+
+// EdgeOffsetZ will return the offset for a point outside of the grid.
+//
+// EdgeExtrapolate extends the nearest cell linearly, regardless of interpolation.
+func (g *Grid) EdgeOffsetZ(x, y float64, mode EdgeMode) float64 {
+	if mode == EdgeExtrapolate {
+		return g.bilinear(span(g.xs, x), span(g.ys, y), x, y)
+	}
+
+	x = math.Max(g.xs[0], math.Min(g.xs[len(g.xs)-1], x))
+	y = math.Max(g.ys[0], math.Min(g.ys[len(g.ys)-1], y))
+	return g.at(x, y)
+}
+
+// SplitXY implements Splitter, returning where the move crosses grid lines.
+func (g *Grid) SplitXY(x0, y0, x1, y1 float64) []float64 {
+	var res []float64
+	cross := func(lines []float64, a, b float64) {
+		for _, v := range lines {
+			t := (v - a) / (b - a)
+			if t > 0 && t < 1 {
+				res = append(res, t)
+			}
+		}
+	}
+	if x0 != x1 {
+		cross(g.xs, x0, x1)
+	}
+	if y0 != y1 {
+		cross(g.ys, y0, y1)
+	}
+	return sortSplits(res)
+}
@@ -0,0 +1,83 @@
+package meshlevel
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrid_OffsetZ(t *testing.T) {
+	tilt := func(x, y float64) float64 { return 0.01*x - 0.02*y + 1 }
+
+	var points []coord.Point
+	for _, y := range []float64{0, 10, 25, 40} {
+		for _, x := range []float64{0, 20, 40} {
+			// small noise in reported positions and a duplicate probe
+			points = append(points, coord.Point{X: x + 0.001, Y: y - 0.001, Z: tilt(x, y)})
+		}
+	}
+	points = append(points, coord.Point{X: 20, Y: 10, Z: tilt(20, 10)})
+
+	for _, cubic := range []bool{false, true} {
+		g, err := NewGrid(points, cubic)
+		assert.NoError(t, err)
+		assert.Len(t, g.xs, 3)
+		assert.Len(t, g.ys, 4)
+
+		// both methods reproduce a plane exactly
+		for _, p := range [][2]float64{{5, 5}, {20, 10}, {33, 37}, {39, 1}} {
+			ok, z := g.OffsetZ(p[0], p[1])
+			assert.True(t, ok)
+			assert.InDelta(t, tilt(p[0], p[1]), z, 0.001)
+		}
+
+		ok, _ := g.OffsetZ(50, 10)
+		assert.False(t, ok)
+		assert.InDelta(t, tilt(40, 10), g.EdgeOffsetZ(50, 10, EdgeClamp), 0.001)
+		assert.InDelta(t, tilt(50, 10), g.EdgeOffsetZ(50, 10, EdgeExtrapolate), 0.001)
+	}
+
+	_, err := NewGrid(append(points, coord.Point{X: 5, Y: 5}), false)
+	assert.Error(t, err)
+}
+
+func TestGrid_Bicubic(t *testing.T) {
+	// smooth curve along X, bicubic should match the midpoint better than bilinear
+	curve := func(x float64) float64 { return x * x / 100 }
+	var points []coord.Point
+	for y := 0.0; y <= 10; y += 10 {
+		for x := 0.0; x <= 40; x += 10 {
+			points = append(points, coord.Point{X: x, Y: y, Z: curve(x)})
+		}
+	}
+
+	lin, err := NewGrid(points, false)
+	assert.NoError(t, err)
+	cub, err := NewGrid(points, true)
+	assert.NoError(t, err)
+
+	_, zl := lin.OffsetZ(15, 5)
+	_, zc := cub.OffsetZ(15, 5)
+	assert.InDelta(t, 2.5, zl, 0.000001)
+	assert.InDelta(t, curve(15), zc, 0.000001)
+}
+
+func TestFitPlane(t *testing.T) {
+	p, err := FitPlane([]coord.Point{
+		{X: 0, Y: 0, Z: 1.1},
+		{X: 10, Y: 0, Z: 1.2},
+		{X: 0, Y: 10, Z: 0.8},
+		{X: 10, Y: 10, Z: 0.9},
+		// noise on the center point should be averaged out
+		{X: 5, Y: 5, Z: 1.05},
+		{X: 5, Y: 5, Z: 0.95},
+	})
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.01, p.A, 0.000001)
+	assert.InDelta(t, -0.03, p.B, 0.000001)
+	assert.InDelta(t, 1.1, p.C, 0.000001)
+
+	_, err = FitPlane([]coord.Point{{X: 0}, {X: 1}, {X: 2}})
+	assert.Error(t, err)
+}
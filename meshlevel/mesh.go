@@ -8,9 +8,24 @@ import (
 	"github.com/mastercactapus/gcnc/coord"
 )
 
+// hullEdge is an edge of the mesh that only belongs to a single triangle.
+type hullEdge struct {
+	a, b coord.Point
+	tri  int
+}
+
 type Mesh struct {
 	minX, minY, maxX, maxY float64
 	triangles              []coord.Triangle
+
+	// hull is the set of edges on the outside of the mesh
+	hull []hullEdge
+
+	// cells is a uniform grid of triangle indexes, covering the bounds of the mesh,
+	// used to find candidate triangles for a point without checking all of them.
+	cells      [][]int
+	cellSize   float64
+	cols, rows int
 }
 
 func NewMesh(points []coord.Point) (*Mesh, error) {
@@ -58,15 +73,74 @@ func NewMesh(points []coord.Point) (*Mesh, error) {
 			C: m[tri.Points[tri.Triangles[i+2]]],
 		})
 	}
+	for e, opposite := range tri.Halfedges {
+		if opposite != -1 {
+			continue
+		}
+		next := e + 1
+		if next%3 == 0 {
+			next -= 3
+		}
+		mesh.hull = append(mesh.hull, hullEdge{
+			a:   m[tri.Points[tri.Triangles[e]]],
+			b:   m[tri.Points[tri.Triangles[next]]],
+			tri: e / 3,
+		})
+	}
+
+	mesh.index()
 
 	return mesh, nil
 }
 
+// index will build the spatial index, sizing cells so that each holds
+// roughly one triangle.
+func (m *Mesh) index() {
+	w, h := m.maxX-m.minX, m.maxY-m.minY
+	m.cellSize = math.Sqrt(w * h / math.Max(1, float64(len(m.triangles))))
+	if m.cellSize <= 0 {
+		// degenerate (collinear) mesh
+		m.cellSize = math.Max(w, h)
+	}
+	m.cols = int(w/m.cellSize) + 1
+	m.rows = int(h/m.cellSize) + 1
+	m.cells = make([][]int, m.cols*m.rows)
+
+	for i, t := range m.triangles {
+		c0, r0 := m.cell(math.Min(t.A.X, math.Min(t.B.X, t.C.X))-coord.Epsilon, math.Min(t.A.Y, math.Min(t.B.Y, t.C.Y))-coord.Epsilon)
+		c1, r1 := m.cell(math.Max(t.A.X, math.Max(t.B.X, t.C.X))+coord.Epsilon, math.Max(t.A.Y, math.Max(t.B.Y, t.C.Y))+coord.Epsilon)
+		for r := r0; r <= r1; r++ {
+			for c := c0; c <= c1; c++ {
+				m.cells[r*m.cols+c] = append(m.cells[r*m.cols+c], i)
+			}
+		}
+	}
+}
+
+// cell will return the index cell for x,y, clamped to the grid.
+func (m Mesh) cell(x, y float64) (int, int) {
+	c := int((x - m.minX) / m.cellSize)
+	r := int((y - m.minY) / m.cellSize)
+	if c < 0 {
+		c = 0
+	} else if c >= m.cols {
+		c = m.cols - 1
+	}
+	if r < 0 {
+		r = 0
+	} else if r >= m.rows {
+		r = m.rows - 1
+	}
+	return c, r
+}
+
 func (m Mesh) OffsetZ(x, y float64) (bool, float64) {
 	if x < m.minX || m.maxX < x || y < m.minY || m.maxY < y {
 		return false, 0
 	}
-	for _, t := range m.triangles {
+	c, r := m.cell(x, y)
+	for _, i := range m.cells[r*m.cols+c] {
+		t := m.triangles[i]
 		if !t.ContainsXY(x, y) {
 			continue
 		}
@@ -75,3 +149,83 @@ func (m Mesh) OffsetZ(x, y float64) (bool, float64) {
 
 	return false, 0
 }
+
+// Triangles returns the triangles that make up the mesh.
+func (m Mesh) Triangles() []coord.Triangle {
+	return m.triangles
+}
+
+// nearestEdge will return the hull edge closest to x,y and the position
+// along it (0-1) of the closest point.
+func (m Mesh) nearestEdge(x, y float64) (hullEdge, float64) {
+	var best hullEdge
+	var bestT float64
+	bestDist := math.Inf(1)
+	for _, e := range m.hull {
+		dx, dy := e.b.X-e.a.X, e.b.Y-e.a.Y
+		t := ((x-e.a.X)*dx + (y-e.a.Y)*dy) / (dx*dx + dy*dy)
+		t = math.Max(0, math.Min(1, t))
+		dist := math.Hypot(e.a.X+dx*t-x, e.a.Y+dy*t-y)
+		if dist < bestDist {
+			best, bestT, bestDist = e, t, dist
+		}
+	}
+	return best, bestT
+}
+
+// EdgeOffsetZ implements EdgeOffsetter using the nearest edge of the mesh.
+func (m Mesh) EdgeOffsetZ(x, y float64, mode EdgeMode) float64 {
+	if len(m.hull) == 0 {
+		return 0
+	}
+	e, t := m.nearestEdge(x, y)
+	if mode == EdgeExtrapolate {
+		return m.triangles[e.tri].Z(x, y)
+	}
+	return e.a.Z + (e.b.Z-e.a.Z)*t
+}
+
+// crossXY will return the position along the move from (x0, y0) by (dx, dy)
+// where it crosses the edge from p to q, if it does.
+func crossXY(x0, y0, dx, dy float64, p, q coord.Point) (float64, bool) {
+	ex, ey := q.X-p.X, q.Y-p.Y
+	den := dx*ey - dy*ex
+	if den == 0 {
+		// parallel
+		return 0, false
+	}
+	wx, wy := p.X-x0, p.Y-y0
+	t := (wx*ey - wy*ex) / den
+	u := (wx*dy - wy*dx) / den
+	if t <= 0 || t >= 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
+
+// SplitXY implements Splitter, returning where the move crosses triangle edges.
+func (m Mesh) SplitXY(x0, y0, x1, y1 float64) []float64 {
+	c0, r0 := m.cell(math.Min(x0, x1), math.Min(y0, y1))
+	c1, r1 := m.cell(math.Max(x0, x1), math.Max(y0, y1))
+	dx, dy := x1-x0, y1-y0
+
+	seen := make(map[int]bool)
+	var res []float64
+	for r := r0; r <= r1; r++ {
+		for c := c0; c <= c1; c++ {
+			for _, i := range m.cells[r*m.cols+c] {
+				if seen[i] {
+					continue
+				}
+				seen[i] = true
+				t := m.triangles[i]
+				for _, e := range [][2]coord.Point{{t.A, t.B}, {t.B, t.C}, {t.C, t.A}} {
+					if v, ok := crossXY(x0, y0, dx, dy, e[0], e[1]); ok {
+						res = append(res, v)
+					}
+				}
+			}
+		}
+	}
+	return sortSplits(res)
+}
@@ -0,0 +1,67 @@
+package meshlevel
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+// gridPoints will return an n x n grid of points over a 100x100 area with a wavy surface.
+func gridPoints(n int) []coord.Point {
+	var points []coord.Point
+	step := 100 / float64(n-1)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			px, py := float64(x)*step, float64(y)*step
+			points = append(points, coord.Point{X: px, Y: py, Z: math.Sin(px/10) + math.Cos(py/7)})
+		}
+	}
+	return points
+}
+
+func TestMesh_OffsetZ(t *testing.T) {
+	mesh, err := NewMesh(gridPoints(20))
+	assert.NoError(t, err)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		x, y := rnd.Float64()*100, rnd.Float64()*100
+
+		// compare against checking every triangle
+		var expected float64
+		var found bool
+		for _, tri := range mesh.triangles {
+			if tri.ContainsXY(x, y) {
+				expected = tri.Z(x, y)
+				found = true
+				break
+			}
+		}
+
+		ok, z := mesh.OffsetZ(x, y)
+		assert.Equal(t, found, ok)
+		assert.InDelta(t, expected, z, 0.000001)
+	}
+
+	ok, _ := mesh.OffsetZ(-1, 50)
+	assert.False(t, ok)
+}
+
+func benchmarkOffsetZ(b *testing.B, n int) {
+	mesh, err := NewMesh(gridPoints(n))
+	if err != nil {
+		b.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mesh.OffsetZ(rnd.Float64()*100, rnd.Float64()*100)
+	}
+}
+
+func BenchmarkMesh_OffsetZ_10x10(b *testing.B)   { benchmarkOffsetZ(b, 10) }
+func BenchmarkMesh_OffsetZ_50x50(b *testing.B)   { benchmarkOffsetZ(b, 50) }
+func BenchmarkMesh_OffsetZ_200x200(b *testing.B) { benchmarkOffsetZ(b, 200) }
@@ -9,7 +9,12 @@ import (
 
 type MeshLeveler struct {
 	granularity float64
+	tolerance   float64
 	offsetter   ZOffsetter
+	edge        EdgeMode
+
+	// zero is where Z was zeroed, absolute moves are leveled relative to it
+	zero coord.Point
 
 	buf  []gcode.Block
 	bufN int
@@ -17,14 +22,32 @@ type MeshLeveler struct {
 	splitVM *gcode.VM
 	levelVM *gcode.VM
 
-	gr gcode.Reader
+	gr   gcode.Reader
+	line int
+
+	maxOffset float64
 }
 type Config struct {
 	ZOffsetter  ZOffsetter
 	Granularity float64
 
+	// Tolerance, if set, is the max Z error allowed between a leveled move and the surface.
+	// Moves are split only where needed to stay within it (at triangle edges or grid lines
+	// for a Splitter) instead of every Granularity. Moves shorter than Granularity are not split.
+	Tolerance float64
+
+	// Edge controls how moves outside of the probed area are handled.
+	// EdgeClamp and EdgeExtrapolate require the ZOffsetter to be an EdgeOffsetter.
+	Edge EdgeMode
+
+	// MPos and WCO are the machine position and work offset at the start of the program.
 	MPos, WCO coord.Point
 
+	// Zero is the machine XY where Z was zeroed. Absolute Z moves are leveled by the
+	// surface height relative to this point, so leveling does not accumulate across blocks.
+	// Defaults to the work origin.
+	Zero *coord.Point
+
 	Reader gcode.Reader
 }
 
@@ -35,9 +58,15 @@ func New(cfg Config) *MeshLeveler {
 		levelVM: gcode.NewVM(),
 
 		granularity: cfg.Granularity,
+		tolerance:   cfg.Tolerance,
 		gr:          cfg.Reader,
 
 		offsetter: cfg.ZOffsetter,
+		edge:      cfg.Edge,
+		zero:      cfg.WCO,
+	}
+	if cfg.Zero != nil {
+		l.zero = *cfg.Zero
 	}
 	if l.offsetter == nil {
 		l.offsetter = dummyOffsetter{}
@@ -51,6 +80,11 @@ func New(cfg Config) *MeshLeveler {
 	return l
 }
 
+// Read will return the next leveled block.
+//
+// Relative Z moves are adjusted by the change in surface height since the last position.
+// Absolute Z moves are adjusted by the surface height relative to where Z was zeroed,
+// so the program's Z values remain heights above the surface there.
 func (l *MeshLeveler) Read() (gcode.Block, error) {
 	b, err := l.next()
 	if err != nil {
@@ -69,36 +103,76 @@ func (l *MeshLeveler) Read() (gcode.Block, error) {
 
 	newWPos := l.levelVM.WPos()
 
-	// get old and new offset
+	// get the new offset relative to the last position (relative moves)
+	// or where Z was zeroed (absolute moves)
 	// if we don't have one (before or after)
 	// then we leave the command as-is
-	ok, oldOffset := l.offsetter.OffsetZ(oldPos.X, oldPos.Y)
+	refPos := l.zero
+	if l.levelVM.RelativeMotion() {
+		refPos = oldPos
+	}
+	ok, refOffset, err := l.offset(refPos.X, refPos.Y)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
 		return b, nil
 	}
-	ok, newOffset := l.offsetter.OffsetZ(newPos.X, newPos.Y)
+	ok, newOffset, err := l.offset(newPos.X, newPos.Y)
+	if err != nil {
+		return nil, err
+	}
 	if !ok {
 		return b, nil
 	}
-	if oldOffset == newOffset {
+	ok, cmdZ := b.Arg('Z')
+	// an absolute move without Z still needs one, as the last move may have been leveled
+	if refOffset == newOffset && (ok || l.levelVM.RelativeMotion()) {
 		return b, nil
 	}
 
+	unit := 1.0
+	if l.levelVM.Inches() {
+		unit = 25.4
+	}
+
 	b = b.Clone()
-	ok, cmdZ := b.Arg('Z')
 	if !l.levelVM.RelativeMotion() && !ok {
-		cmdZ = newWPos.Z
+		cmdZ = newWPos.Z / unit
 	}
 
-	if !ok {
-		b = append(b, gcode.Word{W: 'Z', Arg: cmdZ + (newOffset - oldOffset)})
-	} else {
-		b.SetArg('Z', cmdZ+(newOffset-oldOffset))
-	}
+	b = setArg(b, 'Z', cmdZ+(newOffset-refOffset)/unit)
+	l.maxOffset = math.Max(l.maxOffset, math.Abs(newOffset-refOffset))
 
 	return b, nil
 }
 
+// offset will return the Z offset at x,y according to the edge mode.
+func (l *MeshLeveler) offset(x, y float64) (bool, float64, error) {
+	ok, z := l.offsetter.OffsetZ(x, y)
+	if ok {
+		return true, z, nil
+	}
+
+	switch l.edge {
+	case EdgeFail:
+		return false, 0, ErrOutsideMesh
+	case EdgeClamp, EdgeExtrapolate:
+		if e, ok := l.offsetter.(EdgeOffsetter); ok {
+			return true, e.EdgeOffsetZ(x, y, l.edge), nil
+		}
+	}
+
+	return false, 0, nil
+}
+
+// MaxOffset returns the largest Z adjustment made so far, in mm.
+func (l *MeshLeveler) MaxOffset() float64 { return l.maxOffset }
+
+// Line returns the source line of the last block read, if the
+// underlying reader is a gcode.LineReader.
+func (l *MeshLeveler) Line() int { return l.line }
+
 func (l *MeshLeveler) next() (gcode.Block, error) {
 	if len(l.buf) > 0 {
 		b := l.buf[0]
@@ -109,6 +183,9 @@ func (l *MeshLeveler) next() (gcode.Block, error) {
 	if err != nil {
 		return nil, err
 	}
+	if lr, ok := l.gr.(gcode.LineReader); ok {
+		l.line = lr.Line()
+	}
 
 	oldPos := l.splitVM.WPos()
 	err = l.splitVM.Run(b)
@@ -116,39 +193,160 @@ func (l *MeshLeveler) next() (gcode.Block, error) {
 		return nil, err
 	}
 	newPos := l.splitVM.WPos()
-	if oldPos.Equal(newPos) {
-		return b, nil
+	if ok, arc := l.splitVM.Arc(); ok {
+		l.splitArc(b, arc)
+	} else {
+		if oldPos.Equal(newPos) {
+			return b, nil
+		}
+		dist := oldPos.DistanceXY(newPos.X, newPos.Y)
+		if dist <= l.granularity {
+			return b, nil
+		}
+		l.split(b, oldPos, newPos)
 	}
-	dist := oldPos.DistanceXY(newPos.X, newPos.Y)
-	if dist <= l.granularity {
-		return b, nil
+
+	b = l.buf[0]
+	l.buf = l.buf[1:]
+	return b, nil
+}
+
+// setArg will set the value of w, adding it to the block if needed.
+func setArg(b gcode.Block, w byte, val float64) gcode.Block {
+	if ok, _ := b.Arg(w); ok {
+		b.SetArg(w, val)
+		return b
+	}
+	return append(b, gcode.Word{W: w, Arg: val})
+}
+
+// split will queue copies of b that move from oldPos to newPos (in work
+// coordinates) in steps no longer than the granularity, or within tolerance if set.
+func (l *MeshLeveler) split(b gcode.Block, oldPos, newPos coord.Point) {
+	var steps []float64
+	if l.tolerance > 0 {
+		steps = l.breaks(oldPos, newPos)
+	} else {
+		n := 1
+		if l.granularity > 0 {
+			// TODO: account for rounding errors past (e.g. beyond .00001)?
+			n = int(math.Ceil(oldPos.DistanceXY(newPos.X, newPos.Y) / l.granularity))
+		}
+		if n < 1 {
+			n = 1
+		}
+		steps = make([]float64, n)
+		for i := range steps {
+			steps[i] = float64(i+1) / float64(n)
+		}
 	}
 
-	// TODO: account for rounding errors past (e.g. beyond .00001)?
-	n := int(math.Ceil(dist / l.granularity))
-	distPoint := newPos.Sub(oldPos).Div(float64(n))
+	unit := 1.0
+	if l.splitVM.Inches() {
+		unit = 25.4
+	}
+	dist := newPos.Sub(oldPos)
 
-	if l.splitVM.RelativeMotion() {
+	var last float64
+	for _, t := range steps {
 		bl := b.Clone()
-		bl.SetArg('X', distPoint.X)
-		bl.SetArg('Y', distPoint.Y)
-		bl.SetArg('Z', distPoint.Z)
+		if l.splitVM.RelativeMotion() {
+			d := dist.Mul(t - last)
+			bl.SetArg('X', d.X/unit)
+			bl.SetArg('Y', d.Y/unit)
+			bl.SetArg('Z', d.Z/unit)
+		} else {
+			p := oldPos.Add(dist.Mul(t))
+			bl.SetArg('X', p.X/unit)
+			bl.SetArg('Y', p.Y/unit)
+			bl.SetArg('Z', p.Z/unit)
+		}
+		l.buf = append(l.buf, bl)
+		last = t
+	}
+}
 
-		for i := 1; i <= n; i++ {
-			l.buf = append(l.buf, bl.Clone())
+// breaks will return the positions (0-1, ending with 1) along a move from oldPos to newPos
+// (in work coordinates) where it must be split to stay within tolerance of the surface.
+func (l *MeshLeveler) breaks(oldPos, newPos coord.Point) []float64 {
+	wco := l.splitVM.WCO()
+	a, b := oldPos.Add(wco), newPos.Add(wco)
+	at := func(t float64) float64 {
+		_, z, _ := l.offset(a.X+(b.X-a.X)*t, a.Y+(b.Y-a.Y)*t)
+		return z
+	}
+
+	// start with where the surface bends, if known
+	ts := []float64{0}
+	if s, ok := l.offsetter.(Splitter); ok {
+		ts = append(ts, s.SplitXY(a.X, a.Y, b.X, b.Y)...)
+	}
+	ts = append(ts, 1)
+
+	// bisect anything that is still curved
+	var refine func(t0, z0, t1, z1 float64, depth int)
+	samples := []float64{0}
+	values := []float64{at(0)}
+	refine = func(t0, z0, t1, z1 float64, depth int) {
+		mid := (t0 + t1) / 2
+		zm := at(mid)
+		if depth < 16 && math.Abs(zm-(z0+z1)/2) > l.tolerance {
+			refine(t0, z0, mid, zm, depth+1)
+			samples = append(samples, mid)
+			values = append(values, zm)
+			refine(mid, zm, t1, z1, depth+1)
 		}
-	} else {
-		for i := 1; i <= n; i++ {
-			bl := b.Clone()
-			bl.SetArg('X', oldPos.X+distPoint.X*float64(i))
-			bl.SetArg('Y', oldPos.Y+distPoint.Y*float64(i))
-			bl.SetArg('Z', oldPos.Z+distPoint.Z*float64(i))
+	}
+	for i := 1; i < len(ts); i++ {
+		z := at(ts[i])
+		refine(ts[i-1], values[len(values)-1], ts[i], z, 0)
+		samples = append(samples, ts[i])
+		values = append(values, z)
+	}
 
-			l.buf = append(l.buf, bl)
+	// then drop any points that the surface is straight through
+	var res []float64
+	start := 0
+	for j := 2; j < len(samples); j++ {
+		for k := start + 1; k < j; k++ {
+			f := (samples[k] - samples[start]) / (samples[j] - samples[start])
+			if math.Abs(values[start]+(values[j]-values[start])*f-values[k]) > l.tolerance {
+				res = append(res, samples[j-1])
+				start = j - 1
+				break
+			}
 		}
 	}
+	return append(res, 1)
+}
 
-	b = l.buf[0]
-	l.buf = l.buf[1:]
-	return b, nil
+// splitArc will queue an arc as a series of straight G1 moves.
+func (l *MeshLeveler) splitArc(b gcode.Block, arc gcode.Arc) {
+	// keep everything but the arc itself on the first move
+	var first gcode.Block
+	for _, g := range b {
+		if g.IsAxis() || g.ModalGroup() == gcode.ModalGroupMotion {
+			continue
+		}
+		switch g.W {
+		case 'I', 'J', 'K', 'R':
+			continue
+		}
+		first = append(first, g)
+	}
+
+	wco := l.splitVM.WCO()
+	start := arc.Start.Sub(wco)
+	for i, p := range arc.Points(gcode.ArcTolerance) {
+		p = p.Sub(wco)
+		bl := gcode.Block{{W: 'G', Arg: 1}}
+		if i == 0 {
+			bl = append(bl, first...)
+		}
+		bl = setArg(bl, 'X', 0)
+		bl = setArg(bl, 'Y', 0)
+		bl = setArg(bl, 'Z', 0)
+		l.split(bl, start, p)
+		start = p
+	}
 }
@@ -1,6 +1,8 @@
 package meshlevel
 
 import (
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/mastercactapus/gcnc/coord"
@@ -56,3 +58,173 @@ func TestMeshLeveler(t *testing.T) {
 	assert.Error(t, err)
 
 }
+
+func TestMeshLeveler_Arc(t *testing.T) {
+	cfg := Config{
+		Granularity: 100,
+		Reader:      gcode.NewParser(strings.NewReader("G2 X10 Y0 I5 J0 F100\n")),
+	}
+
+	m := New(cfg)
+
+	b, err := m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.Line())
+	ok, f := b.Arg('F')
+	assert.True(t, ok)
+	assert.Equal(t, 100.0, f)
+
+	var n int
+	for {
+		assert.Equal(t, "G1", b[0].String())
+		_, i := b.Arg('I')
+		assert.Zero(t, i)
+		n++
+
+		next, err := m.Read()
+		if err != nil {
+			break
+		}
+		b = next
+	}
+	assert.True(t, n > 10)
+	assert.Equal(t, "G1X10Y0Z0", b.String())
+}
+
+func TestMeshLeveler_Absolute(t *testing.T) {
+	// rise of .1mm Z for every 1mm X
+	probes := []coord.Point{
+		{X: 0, Y: 0, Z: 0},
+		{X: 0, Y: 10, Z: 0},
+		{X: 10, Y: 0, Z: 1},
+		{X: 10, Y: 10, Z: 1},
+	}
+	mesh, err := NewMesh(probes)
+	assert.NoError(t, err)
+
+	cfg := Config{
+		ZOffsetter:  mesh,
+		Granularity: 5,
+		MPos:        coord.Point{X: 0, Y: 5, Z: 0},
+		Reader:      &gcode.BlocksReader{Blocks: gcode.MustParse("G90 G1 X10 Z-1")},
+	}
+	m := New(cfg)
+
+	// ramping down 1mm while the surface rises 1mm should stay at Z0
+	b, err := m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "G90G1X5Z0", b.String())
+
+	b, err = m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "G90G1X10Z0", b.String())
+}
+
+func TestMeshLeveler_AbsoluteZero(t *testing.T) {
+	// rise of .1mm Z for every 1mm X
+	probes := []coord.Point{
+		{X: 0, Y: 0, Z: 0},
+		{X: 0, Y: 10, Z: 0},
+		{X: 10, Y: 0, Z: 1},
+		{X: 10, Y: 10, Z: 1},
+	}
+	mesh, err := NewMesh(probes)
+	assert.NoError(t, err)
+
+	// Z was zeroed at X5, absolute Z values are heights above the surface there,
+	// regardless of where the program starts or the previous block ended
+	m := New(Config{
+		ZOffsetter:  mesh,
+		Granularity: 100,
+		MPos:        coord.Point{X: 0, Y: 5, Z: 0},
+		Zero:        &coord.Point{X: 5, Y: 5},
+		Reader:      &gcode.BlocksReader{Blocks: gcode.MustParse("G90 G1 X10 Z0\nX0 Z0\nX5 Z-1")},
+	})
+
+	for _, exp := range []string{"G90G1X10Z0.5", "X0Z-0.5", "X5Z-1"} {
+		b, err := m.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, exp, b.String())
+	}
+
+	// without one, the work origin is used
+	m = New(Config{
+		ZOffsetter:  mesh,
+		Granularity: 100,
+		MPos:        coord.Point{X: 5, Y: 5, Z: 0},
+		WCO:         coord.Point{X: 2, Y: 5, Z: 0},
+		Reader:      &gcode.BlocksReader{Blocks: gcode.MustParse("G90 G1 X8 Z0")},
+	})
+	b, err := m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "G90G1X8Z0.8", b.String())
+}
+
+func TestMeshLeveler_Edge(t *testing.T) {
+	// rise of .1mm Z for every 1mm X
+	probes := []coord.Point{
+		{X: 0, Y: 0, Z: 0},
+		{X: 0, Y: 10, Z: 0},
+		{X: 10, Y: 0, Z: 1},
+		{X: 10, Y: 10, Z: 1},
+	}
+	mesh, err := NewMesh(probes)
+	assert.NoError(t, err)
+
+	read := func(edge EdgeMode) (gcode.Block, error) {
+		return New(Config{
+			ZOffsetter:  mesh,
+			Granularity: 100,
+			Edge:        edge,
+			MPos:        coord.Point{X: 5, Y: 5, Z: 0},
+			Zero:        &coord.Point{X: 5, Y: 5},
+			Reader:      &gcode.BlocksReader{Blocks: gcode.MustParse("G90 G1 X15 Z0")},
+		}).Read()
+	}
+
+	b, err := read(EdgeIgnore)
+	assert.NoError(t, err)
+	assert.Equal(t, "G90G1X15Z0", b.String())
+
+	b, err = read(EdgeClamp)
+	assert.NoError(t, err)
+	assert.Equal(t, "G90G1X15Z0.5", b.String())
+
+	b, err = read(EdgeExtrapolate)
+	assert.NoError(t, err)
+	assert.Equal(t, "G90G1X15Z1", b.String())
+
+	_, err = read(EdgeFail)
+	assert.Equal(t, ErrOutsideMesh, err)
+}
+
+func TestMeshLeveler_Tolerance(t *testing.T) {
+	// ridge along X=5, flat on either side of it
+	probes := []coord.Point{
+		{X: 0, Y: 0, Z: 0},
+		{X: 0, Y: 10, Z: 0},
+		{X: 5, Y: 0, Z: 1},
+		{X: 5, Y: 10, Z: 1},
+		{X: 10, Y: 0, Z: 0},
+		{X: 10, Y: 10, Z: 0},
+	}
+	mesh, err := NewMesh(probes)
+	assert.NoError(t, err)
+
+	cfg := Config{
+		ZOffsetter: mesh,
+		MPos:       coord.Point{X: 0, Y: 5},
+		Tolerance:  0.01,
+		Reader:     &gcode.BlocksReader{Blocks: gcode.MustParse("G1 X10 Y5")},
+	}
+	m := New(cfg)
+
+	b, err := m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "G1X5Y5Z1", b.String())
+	b, err = m.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "G1X10Y5Z0", b.String())
+	_, err = m.Read()
+	assert.Equal(t, io.EOF, err)
+}
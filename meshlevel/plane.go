@@ -0,0 +1,56 @@
+package meshlevel
+
+import (
+	"errors"
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// Plane is a flat, tilted surface with Z = A*X + B*Y + C.
+//
+// It compensates for tilt only and applies everywhere, so edge modes have no effect.
+type Plane struct {
+	A, B, C float64
+}
+
+// FitPlane will find the least-squares Plane through a set of points.
+func FitPlane(points []coord.Point) (*Plane, error) {
+	if len(points) < 3 {
+		return nil, errors.New("need at least 3 points to fit a plane")
+	}
+
+	// center the points to keep the normal equations well conditioned
+	var mean coord.Point
+	for _, p := range points {
+		mean = mean.Add(p)
+	}
+	mean = mean.Div(float64(len(points)))
+
+	var xx, xy, yy, xz, yz float64
+	for _, p := range points {
+		d := p.Sub(mean)
+		xx += d.X * d.X
+		xy += d.X * d.Y
+		yy += d.Y * d.Y
+		xz += d.X * d.Z
+		yz += d.Y * d.Z
+	}
+
+	det := xx*yy - xy*xy
+	if math.Abs(det) < coord.Epsilon {
+		return nil, errors.New("points are collinear")
+	}
+
+	p := &Plane{
+		A: (xz*yy - yz*xy) / det,
+		B: (yz*xx - xz*xy) / det,
+	}
+	p.C = mean.Z - p.A*mean.X - p.B*mean.Y
+	return p, nil
+}
+
+// OffsetZ will return the height of the plane at (x, y).
+func (p Plane) OffsetZ(x, y float64) (bool, float64) {
+	return true, p.A*x + p.B*y + p.C
+}
@@ -1,9 +1,115 @@
 package meshlevel
 
+import (
+	"errors"
+	"sort"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// ErrOutsideMesh is returned when leveling with EdgeFail and a move
+// falls outside of the probed area.
+var ErrOutsideMesh = errors.New("move is outside of the probed area")
+
 type ZOffsetter interface {
 	OffsetZ(x, y float64) (bool, float64)
 }
 
+// EdgeMode controls how points outside of the probed area are leveled.
+type EdgeMode string
+
+// Supported edge modes.
+const (
+	// EdgeIgnore will leave moves outside of the probed area unleveled.
+	EdgeIgnore EdgeMode = ""
+	// EdgeClamp will use the offset of the nearest point on the edge of the probed area.
+	EdgeClamp EdgeMode = "clamp"
+	// EdgeExtrapolate will extend the surface at the nearest edge of the probed area.
+	EdgeExtrapolate EdgeMode = "extrapolate"
+	// EdgeFail will abort with ErrOutsideMesh.
+	EdgeFail EdgeMode = "fail"
+)
+
+// ParseEdgeMode will validate an EdgeMode.
+func ParseEdgeMode(s string) (EdgeMode, error) {
+	switch m := EdgeMode(s); m {
+	case EdgeIgnore, EdgeClamp, EdgeExtrapolate, EdgeFail:
+		return m, nil
+	}
+	return "", errors.New("unknown edge mode: " + s)
+}
+
+// An EdgeOffsetter can provide offsets outside of the probed area.
+type EdgeOffsetter interface {
+	ZOffsetter
+
+	// EdgeOffsetZ will return the offset for a point outside of the probed area
+	// using EdgeClamp or EdgeExtrapolate.
+	EdgeOffsetZ(x, y float64, mode EdgeMode) float64
+}
+
+// A Splitter can report where a straight move crosses a bend in its surface,
+// so that moves only need to be split where the surface changes slope.
+type Splitter interface {
+	// SplitXY will return the positions along the move from (x0, y0) to (x1, y1),
+	// as fractions between 0 and 1 (exclusive) in ascending order, where the surface bends.
+	SplitXY(x0, y0, x1, y1 float64) []float64
+}
+
+// sortSplits will sort positions, removing duplicates.
+func sortSplits(t []float64) []float64 {
+	if len(t) == 0 {
+		return t
+	}
+	sort.Float64s(t)
+	res := t[:1]
+	for _, v := range t[1:] {
+		if v-res[len(res)-1] > 1e-9 {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// Model selects how probe points are turned into a ZOffsetter.
+type Model string
+
+// Supported models.
+const (
+	// ModelMesh interpolates linearly over a triangulation of the points.
+	ModelMesh Model = ""
+	// ModelBilinear interpolates bilinearly over a regular grid of points.
+	ModelBilinear Model = "bilinear"
+	// ModelBicubic interpolates with a smooth bicubic spline over a regular grid of points.
+	ModelBicubic Model = "bicubic"
+	// ModelPlane fits a single plane to the points, compensating for tilt only.
+	ModelPlane Model = "plane"
+)
+
+// ParseModel will validate a Model.
+func ParseModel(s string) (Model, error) {
+	switch m := Model(s); m {
+	case ModelMesh, ModelBilinear, ModelBicubic, ModelPlane:
+		return m, nil
+	}
+	return "", errors.New("unknown model: " + s)
+}
+
+// NewZOffsetter will create a ZOffsetter for points using the given model.
+func NewZOffsetter(model Model, points []coord.Point) (ZOffsetter, error) {
+	switch model {
+	case ModelMesh:
+		return NewMesh(points)
+	case ModelBilinear:
+		return NewGrid(points, false)
+	case ModelBicubic:
+		return NewGrid(points, true)
+	case ModelPlane:
+		return FitPlane(points)
+	}
+	return nil, errors.New("unknown model: " + string(model))
+}
+
 type dummyOffsetter struct {
 }
 
@@ -0,0 +1,41 @@
+package preview
+
+import (
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func newPNGCanvas(width, height int) *pngCanvas {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(colorBackground), image.Point{}, draw.Src)
+	return &pngCanvas{img: img}
+}
+
+// line will draw a 1px line by stepping along the major axis.
+func (c *pngCanvas) line(l line) {
+	dx := l.x1 - l.x0
+	dy := l.y1 - l.y0
+	n := int(math.Ceil(math.Max(math.Abs(dx), math.Abs(dy))))
+	if n == 0 {
+		c.img.SetRGBA(int(l.x0), int(l.y0), l.style.color)
+		return
+	}
+	for i := 0; i <= n; i++ {
+		if l.style.dashed && i%7 >= 4 {
+			continue
+		}
+		t := float64(i) / float64(n)
+		c.img.SetRGBA(int(math.Round(l.x0+dx*t)), int(math.Round(l.y0+dy*t)), l.style.color)
+	}
+}
+
+func (c *pngCanvas) flush(w io.Writer) error {
+	return png.Encode(w, c.img)
+}
@@ -0,0 +1,155 @@
+package preview
+
+import (
+	"errors"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// View selects the projection used when rendering.
+type View string
+
+// Supported views.
+const (
+	Top   View = "top"
+	Front View = "front"
+	Iso   View = "iso"
+)
+
+var (
+	colorBackground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	colorMesh       = color.RGBA{R: 0xbd, G: 0xc3, B: 0xc7, A: 0xff}
+	colorRapid      = color.RGBA{R: 0xe6, G: 0x7e, B: 0x22, A: 0xff}
+	colorFeed       = color.RGBA{R: 0x29, G: 0x80, B: 0xb9, A: 0xff}
+	colorPlunge     = color.RGBA{R: 0xc0, G: 0x39, B: 0x2b, A: 0xff}
+)
+
+// Options configure a preview rendering.
+type Options struct {
+	View          View
+	Width, Height int
+
+	// Mesh is an optional set of probe triangles to draw under the toolpath.
+	Mesh []coord.Triangle
+}
+
+type style struct {
+	color  color.RGBA
+	dashed bool
+}
+
+type line struct {
+	x0, y0, x1, y1 float64
+	style          style
+}
+
+// canvas is implemented by each output format.
+type canvas interface {
+	line(l line)
+	flush(w io.Writer) error
+}
+
+func motionStyle(m toolpath.Motion) style {
+	switch m {
+	case toolpath.Rapid:
+		return style{color: colorRapid, dashed: true}
+	case toolpath.Plunge:
+		return style{color: colorPlunge}
+	}
+	return style{color: colorFeed}
+}
+
+// project will map a 3D point to 2D for the given view, with Y increasing upwards.
+func project(v View, p coord.Point) (float64, float64) {
+	switch v {
+	case Front:
+		return p.X, p.Z
+	case Iso:
+		return (p.X - p.Y) * math.Cos(math.Pi/6), (p.X+p.Y)*math.Sin(math.Pi/6) + p.Z
+	}
+	return p.X, p.Y
+}
+
+// layout will project all lines and scale them to fit the output size.
+func layout(segs []toolpath.Segment, opt Options) ([]line, error) {
+	switch opt.View {
+	case Top, Front, Iso:
+	default:
+		return nil, errors.New("unknown view: " + string(opt.View))
+	}
+	if opt.Width <= 0 || opt.Height <= 0 {
+		return nil, errors.New("invalid output size")
+	}
+
+	var lines []line
+	add := func(a, b coord.Point, s style) {
+		var l line
+		l.x0, l.y0 = project(opt.View, a)
+		l.x1, l.y1 = project(opt.View, b)
+		l.style = s
+		lines = append(lines, l)
+	}
+	for _, t := range opt.Mesh {
+		s := style{color: colorMesh}
+		add(t.A, t.B, s)
+		add(t.B, t.C, s)
+		add(t.C, t.A, s)
+	}
+	for _, s := range segs {
+		add(s.Start, s.End, motionStyle(s.Motion))
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, l := range lines {
+		minX = math.Min(minX, math.Min(l.x0, l.x1))
+		maxX = math.Max(maxX, math.Max(l.x0, l.x1))
+		minY = math.Min(minY, math.Min(l.y0, l.y1))
+		maxY = math.Max(maxY, math.Max(l.y0, l.y1))
+	}
+
+	const margin = 10
+	w := float64(opt.Width - 2*margin)
+	h := float64(opt.Height - 2*margin)
+	scale := math.Min(w/math.Max(maxX-minX, coord.Epsilon), h/math.Max(maxY-minY, coord.Epsilon))
+
+	// center the drawing
+	offX := margin + (w-(maxX-minX)*scale)/2
+	offY := margin + (h-(maxY-minY)*scale)/2
+	for i, l := range lines {
+		lines[i].x0 = offX + (l.x0-minX)*scale
+		lines[i].x1 = offX + (l.x1-minX)*scale
+		lines[i].y0 = float64(opt.Height) - (offY + (l.y0-minY)*scale)
+		lines[i].y1 = float64(opt.Height) - (offY + (l.y1-minY)*scale)
+	}
+
+	return lines, nil
+}
+
+func render(c canvas, w io.Writer, segs []toolpath.Segment, opt Options) error {
+	lines, err := layout(segs, opt)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		c.line(l)
+	}
+	return c.flush(w)
+}
+
+// SVG will render the toolpath as an SVG image.
+func SVG(w io.Writer, segs []toolpath.Segment, opt Options) error {
+	return render(newSVGCanvas(opt.Width, opt.Height), w, segs, opt)
+}
+
+// PNG will render the toolpath as a PNG image.
+func PNG(w io.Writer, segs []toolpath.Segment, opt Options) error {
+	return render(newPNGCanvas(opt.Width, opt.Height), w, segs, opt)
+}
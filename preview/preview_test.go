@@ -0,0 +1,31 @@
+package preview
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/toolpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSVG(t *testing.T) {
+	segs := []toolpath.Segment{
+		{Start: coord.Point{}, End: coord.Point{X: 10, Y: 10}, Motion: toolpath.Rapid},
+		{Start: coord.Point{X: 10, Y: 10}, End: coord.Point{X: 10, Y: 10, Z: -1}, Motion: toolpath.Plunge},
+		{Start: coord.Point{X: 10, Y: 10, Z: -1}, End: coord.Point{X: 20, Y: 10, Z: -1}, Motion: toolpath.Feed},
+	}
+
+	var buf bytes.Buffer
+	err := SVG(&buf, segs, Options{View: Top, Width: 120, Height: 70})
+	assert.NoError(t, err)
+
+	// 20x10mm drawn into 100x50px
+	assert.Contains(t, buf.String(), `<line x1="10.00" y1="60.00" x2="60.00" y2="10.00" stroke="#e67e22" stroke-dasharray="4 3"/>`)
+	assert.Contains(t, buf.String(), `<line x1="60.00" y1="10.00" x2="110.00" y2="10.00" stroke="#2980b9"/>`)
+	assert.Equal(t, 3, strings.Count(buf.String(), "<line"))
+
+	err = SVG(&buf, segs, Options{View: "side", Width: 120, Height: 70})
+	assert.Error(t, err)
+}
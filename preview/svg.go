@@ -0,0 +1,37 @@
+package preview
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+type svgCanvas struct {
+	width, height int
+	lines         []line
+}
+
+func newSVGCanvas(width, height int) *svgCanvas {
+	return &svgCanvas{width: width, height: height}
+}
+
+func (c *svgCanvas) line(l line) { c.lines = append(c.lines, l) }
+
+func (c *svgCanvas) flush(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", c.width, c.height, c.width, c.height)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="#%02x%02x%02x"/>`+"\n", colorBackground.R, colorBackground.G, colorBackground.B)
+	for _, l := range c.lines {
+		dash := ""
+		if l.style.dashed {
+			dash = ` stroke-dasharray="4 3"`
+		}
+		fmt.Fprintf(bw, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="#%02x%02x%02x"%s/>`+"\n",
+			l.x0, l.y0, l.x1, l.y1,
+			l.style.color.R, l.style.color.G, l.style.color.B,
+			dash,
+		)
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
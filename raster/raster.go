@@ -0,0 +1,306 @@
+package raster
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/mastercactapus/gcnc/gcode"
+)
+
+// Dither selects how pixel intensity is mapped to laser power.
+type Dither string
+
+// Supported dithering modes.
+const (
+	// DitherNone maps intensity directly to power (grayscale).
+	DitherNone Dither = ""
+	// DitherThreshold fires the laser at full power for any pixel darker than 50%.
+	DitherThreshold Dither = "threshold"
+	// DitherFloydSteinberg uses error diffusion to produce a binary image.
+	DitherFloydSteinberg Dither = "floyd-steinberg"
+	// DitherOrdered uses a 4x4 Bayer matrix to produce a binary image.
+	DitherOrdered Dither = "ordered"
+)
+
+// Options configure raster engraving.
+type Options struct {
+	// Width is the engraved width in mm. Height follows the image aspect ratio.
+	Width float64
+
+	// Resolution is the size of a single dot (and the distance between scanlines) in mm.
+	Resolution float64
+
+	FeedRate float64
+
+	// MinPower and MaxPower are the S values used for the lightest
+	// and darkest non-blank pixels.
+	MinPower, MaxPower float64
+
+	Dither Dither
+
+	// Invert will engrave light pixels instead of dark ones.
+	Invert bool
+
+	// Overscan is the distance to travel past either end of a scanline
+	// so the head is at speed when the laser fires.
+	Overscan float64
+
+	// SkipBlank will skip blank rows entirely and rapid over blank
+	// runs that are longer than the overscan on both sides.
+	SkipBlank bool
+
+	// Bidirectional will engrave every other line in reverse.
+	Bidirectional bool
+}
+
+var bayer4 = [4][4]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+func (opt Options) validate() error {
+	if opt.Width <= 0 {
+		return errors.New("width must be positive")
+	}
+	if opt.Resolution <= 0 {
+		return errors.New("resolution must be positive")
+	}
+	if opt.FeedRate <= 0 {
+		return errors.New("feed rate must be positive")
+	}
+	if opt.MaxPower <= 0 || opt.MinPower < 0 || opt.MinPower > opt.MaxPower {
+		return errors.New("invalid power range")
+	}
+	switch opt.Dither {
+	case DitherNone, DitherThreshold, DitherFloydSteinberg, DitherOrdered:
+	default:
+		return errors.New("unknown dither mode: " + string(opt.Dither))
+	}
+	return nil
+}
+
+// Sample will scale img to cols x rows and return the darkness (0-1) of each cell.
+//
+// Each cell is the average of the source pixels it covers, with transparent
+// pixels treated as white.
+func Sample(img image.Image, cols, rows int, invert bool) [][]float64 {
+	bounds := img.Bounds()
+	sx := float64(bounds.Dx()) / float64(cols)
+	sy := float64(bounds.Dy()) / float64(rows)
+
+	res := make([][]float64, rows)
+	for r := range res {
+		res[r] = make([]float64, cols)
+		y0 := bounds.Min.Y + int(float64(r)*sy)
+		y1 := bounds.Min.Y + int(math.Ceil(float64(r+1)*sy))
+		for c := range res[r] {
+			x0 := bounds.Min.X + int(float64(c)*sx)
+			x1 := bounds.Min.X + int(math.Ceil(float64(c+1)*sx))
+
+			var sum float64
+			var n int
+			for y := y0; y < y1 && y < bounds.Max.Y; y++ {
+				for x := x0; x < x1 && x < bounds.Max.X; x++ {
+					_, _, _, a := img.At(x, y).RGBA()
+					g := color.Gray16Model.Convert(img.At(x, y)).(color.Gray16)
+					// composite over white
+					lum := (float64(g.Y) + float64(0xffff-a)) / 0xffff
+					sum += math.Min(lum, 1)
+					n++
+				}
+			}
+			d := 0.0
+			if n > 0 {
+				d = 1 - sum/float64(n)
+			}
+			if invert {
+				d = 1 - d
+			}
+			res[r][c] = d
+		}
+	}
+	return res
+}
+
+// dither will apply the dither mode to the darkness values in-place.
+func dither(px [][]float64, mode Dither) {
+	switch mode {
+	case DitherThreshold:
+		for _, row := range px {
+			for c, d := range row {
+				if d >= 0.5 {
+					row[c] = 1
+				} else {
+					row[c] = 0
+				}
+			}
+		}
+	case DitherOrdered:
+		for r, row := range px {
+			for c, d := range row {
+				if d > (bayer4[r%4][c%4]+0.5)/16 {
+					row[c] = 1
+				} else {
+					row[c] = 0
+				}
+			}
+		}
+	case DitherFloydSteinberg:
+		spread := func(r, c int, e float64) {
+			if r < len(px) && c >= 0 && c < len(px[r]) {
+				px[r][c] += e
+			}
+		}
+		for r, row := range px {
+			for c, d := range row {
+				v := 0.0
+				if d >= 0.5 {
+					v = 1
+				}
+				row[c] = v
+				e := d - v
+				spread(r, c+1, e*7/16)
+				spread(r+1, c-1, e*3/16)
+				spread(r+1, c, e*5/16)
+				spread(r+1, c+1, e*1/16)
+			}
+		}
+	}
+}
+
+// power will return the S value for a darkness level.
+func (opt Options) power(d float64) float64 {
+	if d <= 0 {
+		return 0
+	}
+	if d > 1 {
+		d = 1
+	}
+	return math.Round(opt.MinPower + d*(opt.MaxPower-opt.MinPower))
+}
+
+type run struct {
+	start, end int
+	power      float64
+}
+
+// runs will group a row into runs of equal power.
+func (opt Options) runs(row []float64) []run {
+	var res []run
+	for c, d := range row {
+		s := opt.power(d)
+		if len(res) > 0 && res[len(res)-1].power == s {
+			res[len(res)-1].end = c + 1
+			continue
+		}
+		res = append(res, run{start: c, end: c + 1, power: s})
+	}
+	return res
+}
+
+// Generate will create gcode to engrave img using Grbl laser mode.
+//
+// The origin is the bottom-left corner of the image. Power is set with
+// M4 (dynamic) so the laser scales with the actual speed of the head.
+func Generate(img image.Image, opt Options) ([]gcode.Block, error) {
+	err := opt.validate()
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	cols := int(math.Round(opt.Width / opt.Resolution))
+	if cols < 1 {
+		cols = 1
+	}
+	rows := int(math.Round(float64(cols) * float64(bounds.Dy()) / float64(bounds.Dx())))
+	if rows < 1 {
+		rows = 1
+	}
+
+	px := Sample(img, cols, rows, opt.Invert)
+	dither(px, opt.Dither)
+
+	b := []gcode.Block{
+		{{W: 'G', Arg: 21}, {W: 'G', Arg: 90}},
+		{{W: 'M', Arg: 4}, {W: 'S', Arg: 0}},
+	}
+
+	// rapids are always laser-off in laser mode
+	rapid := func(x, y float64) {
+		b = append(b, gcode.Block{{W: 'G', Arg: 0}, {W: 'X', Arg: x}, {W: 'Y', Arg: y}})
+	}
+	var feedSet bool
+	feed := func(x, s float64) {
+		bl := gcode.Block{{W: 'G', Arg: 1}, {W: 'X', Arg: x}, {W: 'S', Arg: s}}
+		if !feedSet {
+			bl = append(bl, gcode.Word{W: 'F', Arg: opt.FeedRate})
+			feedSet = true
+		}
+		b = append(b, bl)
+	}
+
+	edge := func(c int) float64 { return float64(c) * opt.Resolution }
+
+	var line int
+	for r := range px {
+		y := float64(rows-1-r)*opt.Resolution + opt.Resolution/2
+		runs := opt.runs(px[r])
+		if opt.SkipBlank {
+			for len(runs) > 0 && runs[0].power == 0 {
+				runs = runs[1:]
+			}
+			for len(runs) > 0 && runs[len(runs)-1].power == 0 {
+				runs = runs[:len(runs)-1]
+			}
+			if len(runs) == 0 {
+				continue
+			}
+		}
+
+		reverse := opt.Bidirectional && line%2 == 1
+		line++
+		dir := 1.0
+		if reverse {
+			dir = -1
+			for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+				runs[i], runs[j] = runs[j], runs[i]
+			}
+			for i := range runs {
+				runs[i].start, runs[i].end = runs[i].end, runs[i].start
+			}
+		}
+
+		start := edge(runs[0].start)
+		rapid(start-dir*opt.Overscan, y)
+		if opt.Overscan > 0 {
+			feed(start, 0)
+		}
+		for i, rn := range runs {
+			end := edge(rn.end)
+			blank := rn.power == 0 && i > 0 && i < len(runs)-1
+			if blank && opt.SkipBlank && math.Abs(end-edge(rn.start)) > 2*opt.Overscan {
+				rapid(end-dir*opt.Overscan, y)
+				if opt.Overscan > 0 {
+					feed(end, 0)
+				}
+				continue
+			}
+			feed(end, rn.power)
+		}
+		if opt.Overscan > 0 {
+			feed(edge(runs[len(runs)-1].end)+dir*opt.Overscan, 0)
+		}
+	}
+
+	b = append(b, gcode.Block{{W: 'M', Arg: 5}})
+
+	return b, nil
+}
@@ -0,0 +1,91 @@
+package raster
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func blockStrings(b []gcode.Block) []string {
+	res := make([]string, len(b))
+	for i, bl := range b {
+		res[i] = bl.String()
+	}
+	return res
+}
+
+func TestGenerate(t *testing.T) {
+	// 4x2 image, top row: white, black, black, white
+	// bottom row: all white
+	img := image.NewGray(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		img.SetGray(x, 0, color.Gray{Y: 255})
+		img.SetGray(x, 1, color.Gray{Y: 255})
+	}
+	img.SetGray(1, 0, color.Gray{Y: 0})
+	img.SetGray(2, 0, color.Gray{Y: 0})
+
+	b, err := Generate(img, Options{
+		Width:      4,
+		Resolution: 1,
+		FeedRate:   1000,
+		MaxPower:   1000,
+		Overscan:   2,
+		SkipBlank:  true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"G21G90",
+		"M4S0",
+		"G0X-1Y1.5",
+		"G1X1S0F1000",
+		"G1X3S1000",
+		"G1X5S0",
+		"M5",
+	}, blockStrings(b))
+}
+
+func TestGenerate_Bidirectional(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+
+	b, err := Generate(img, Options{
+		Width:         2,
+		Resolution:    1,
+		FeedRate:      1000,
+		MaxPower:      1000,
+		Bidirectional: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"G21G90",
+		"M4S0",
+		"G0X0Y1.5",
+		"G1X2S1000F1000",
+		"G0X2Y0.5",
+		"G1X0S1000",
+		"M5",
+	}, blockStrings(b))
+}
+
+func TestDither(t *testing.T) {
+	px := [][]float64{{0.25, 0.75, 0.5, 0.1}}
+	dither(px, DitherThreshold)
+	assert.Equal(t, [][]float64{{0, 1, 1, 0}}, px)
+
+	// 50% gray should come out as roughly half on
+	px = [][]float64{make([]float64, 16)}
+	for i := range px[0] {
+		px[0][i] = 0.5
+	}
+	dither(px, DitherFloydSteinberg)
+	var on int
+	for _, v := range px[0] {
+		if v == 1 {
+			on++
+		}
+	}
+	assert.Equal(t, 8, on)
+}
@@ -0,0 +1,321 @@
+package relief
+
+import (
+	"errors"
+	"image"
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/mastercactapus/gcnc/raster"
+)
+
+// Options configure a relief carving.
+type Options struct {
+	// Width is the carved width in mm. Height follows the image aspect ratio.
+	Width float64
+
+	// Resolution is the size of a single heightmap cell in mm.
+	Resolution float64
+
+	// Depth is how far below Z0 the darkest pixels are carved.
+	Depth float64
+
+	// Invert will carve light pixels deepest instead of dark ones.
+	Invert bool
+
+	ToolDiameter float64
+	BallEnd      bool
+
+	// Stepover is the distance between finishing passes.
+	Stepover float64
+
+	// Stepdown is the max depth of each roughing layer. If zero, no roughing is done.
+	Stepdown float64
+
+	// RoughStepover is the distance between roughing passes. Defaults to
+	// half the tool diameter.
+	RoughStepover float64
+
+	// StockToLeave is left on the surface by roughing for the finishing pass.
+	StockToLeave float64
+
+	SafeZ      float64
+	FeedRate   float64
+	PlungeRate float64
+}
+
+func (opt Options) validate() error {
+	if opt.Width <= 0 {
+		return errors.New("width must be positive")
+	}
+	if opt.Resolution <= 0 {
+		return errors.New("resolution must be positive")
+	}
+	if opt.Depth <= 0 {
+		return errors.New("depth must be positive")
+	}
+	if opt.ToolDiameter <= 0 {
+		return errors.New("tool diameter must be positive")
+	}
+	if opt.Stepover <= 0 {
+		return errors.New("stepover must be positive")
+	}
+	if opt.Stepdown < 0 || opt.RoughStepover < 0 || opt.StockToLeave < 0 {
+		return errors.New("roughing options must not be negative")
+	}
+	if opt.SafeZ <= 0 {
+		return errors.New("safe Z must be above the stock")
+	}
+	if opt.FeedRate <= 0 || opt.PlungeRate <= 0 {
+		return errors.New("feed rates must be positive")
+	}
+	return nil
+}
+
+// Heightmap is a grid of Z values, with row 0 at the top (max Y) of the image.
+type Heightmap struct {
+	Resolution float64
+	Z          [][]float64
+}
+
+// NewHeightmap will create a heightmap from img, where black is -depth and white is 0.
+func NewHeightmap(img image.Image, opt Options) *Heightmap {
+	bounds := img.Bounds()
+	cols := int(math.Round(opt.Width / opt.Resolution))
+	if cols < 1 {
+		cols = 1
+	}
+	rows := int(math.Round(float64(cols) * float64(bounds.Dy()) / float64(bounds.Dx())))
+	if rows < 1 {
+		rows = 1
+	}
+
+	z := raster.Sample(img, cols, rows, opt.Invert)
+	for _, row := range z {
+		for c, d := range row {
+			row[c] = -d * opt.Depth
+		}
+	}
+
+	return &Heightmap{Resolution: opt.Resolution, Z: z}
+}
+
+// Point will return the XY position of the center of a cell, with Z set to the cell height.
+func (h *Heightmap) Point(r, c int) coord.Point {
+	return coord.Point{
+		X: (float64(c) + 0.5) * h.Resolution,
+		Y: (float64(len(h.Z)-1-r) + 0.5) * h.Resolution,
+		Z: h.Z[r][c],
+	}
+}
+
+// Compensate will return a heightmap of tool-tip Z values such that a tool
+// of the given radius never cuts below any cell.
+//
+// For a ball-end tool, the tip is raised by the profile of the ball over
+// each neighbouring cell; for a flat tool it is the highest cell under the tool.
+func (h *Heightmap) Compensate(radius float64, ball bool) *Heightmap {
+	k := int(math.Ceil(radius / h.Resolution))
+
+	// precompute the tool profile for each cell offset
+	type offset struct {
+		dr, dc int
+		lift   float64
+	}
+	var profile []offset
+	for dr := -k; dr <= k; dr++ {
+		for dc := -k; dc <= k; dc++ {
+			d := math.Hypot(float64(dr), float64(dc)) * h.Resolution
+			if d > radius {
+				continue
+			}
+			var lift float64
+			if ball {
+				lift = math.Sqrt(radius*radius-d*d) - radius
+			}
+			profile = append(profile, offset{dr: dr, dc: dc, lift: lift})
+		}
+	}
+
+	res := &Heightmap{Resolution: h.Resolution, Z: make([][]float64, len(h.Z))}
+	for r, row := range h.Z {
+		res.Z[r] = make([]float64, len(row))
+		for c := range row {
+			z := math.Inf(-1)
+			for _, o := range profile {
+				rr, cc := r+o.dr, c+o.dc
+				if rr < 0 || rr >= len(h.Z) || cc < 0 || cc >= len(row) {
+					continue
+				}
+				z = math.Max(z, h.Z[rr][cc]+o.lift)
+			}
+			res.Z[r][c] = z
+		}
+	}
+	return res
+}
+
+// min will return the lowest Z value.
+func (h *Heightmap) min() float64 {
+	z := 0.0
+	for _, row := range h.Z {
+		for _, v := range row {
+			z = math.Min(z, v)
+		}
+	}
+	return z
+}
+
+// rows will return the row indexes to cut for the given stepover, always
+// including the first and last.
+func (h *Heightmap) rows(stepover float64) []int {
+	step := stepover / h.Resolution
+	var res []int
+	for f := 0.0; int(math.Round(f)) < len(h.Z); f += step {
+		res = append(res, int(math.Round(f)))
+	}
+	if res[len(res)-1] != len(h.Z)-1 {
+		res = append(res, len(h.Z)-1)
+	}
+	return res
+}
+
+type generator struct {
+	opt Options
+	b   []gcode.Block
+}
+
+func (g *generator) rapidZ(z float64) {
+	g.b = append(g.b, gcode.Block{{W: 'G', Arg: 0}, {W: 'Z', Arg: z}})
+}
+func (g *generator) rapidXY(p coord.Point) {
+	g.b = append(g.b, gcode.Block{{W: 'G', Arg: 0}, {W: 'X', Arg: p.X}, {W: 'Y', Arg: p.Y}})
+}
+func (g *generator) plunge(z float64) {
+	g.b = append(g.b, gcode.Block{{W: 'G', Arg: 1}, {W: 'Z', Arg: z}, {W: 'F', Arg: g.opt.PlungeRate}})
+}
+
+// collinear returns true if b lies on the line from a to c.
+func collinear(a, b, c coord.Point) bool {
+	ab := b.Sub(a)
+	bc := c.Sub(b)
+	return ab.Cross(bc).Equal(coord.Point{}) && ab.Dot(bc) > 0
+}
+
+// cut will feed through the points, skipping any that are collinear
+// with their neighbours.
+func (g *generator) cut(pts []coord.Point) {
+	for i, p := range pts {
+		if i > 0 && i < len(pts)-1 && collinear(pts[i-1], p, pts[i+1]) {
+			continue
+		}
+		bl := gcode.Block{{W: 'G', Arg: 1}, {W: 'X', Arg: p.X}, {W: 'Y', Arg: p.Y}, {W: 'Z', Arg: p.Z}}
+		if i == 0 {
+			bl = append(bl, gcode.Word{W: 'F', Arg: g.opt.FeedRate})
+		}
+		g.b = append(g.b, bl)
+	}
+}
+
+// rough will clear material in layers down to the compensated surface plus StockToLeave.
+func (g *generator) rough(comp *Heightmap) {
+	stepover := g.opt.RoughStepover
+	if stepover == 0 {
+		stepover = g.opt.ToolDiameter / 2
+	}
+	rows := comp.rows(stepover)
+	bottom := comp.min() + g.opt.StockToLeave
+
+	prev := 0.0
+	for layer := -g.opt.Stepdown; prev > bottom; layer -= g.opt.Stepdown {
+		layer = math.Max(layer, bottom)
+		for i, r := range rows {
+			var seg []coord.Point
+			flush := func() {
+				if len(seg) == 0 {
+					return
+				}
+				g.rapidZ(g.opt.SafeZ)
+				g.rapidXY(seg[0])
+				g.plunge(seg[0].Z)
+				g.cut(seg)
+				g.rapidZ(g.opt.SafeZ)
+				seg = seg[:0]
+			}
+
+			cols := len(comp.Z[r])
+			for n := 0; n < cols; n++ {
+				c := n
+				if i%2 == 1 {
+					c = cols - 1 - n
+				}
+				p := comp.Point(r, c)
+				p.Z = math.Max(layer, p.Z+g.opt.StockToLeave)
+				if p.Z >= prev {
+					// nothing left to cut at this depth
+					flush()
+					continue
+				}
+				seg = append(seg, p)
+			}
+			flush()
+		}
+		prev = layer
+	}
+}
+
+// finish will follow the compensated surface in a zig-zag pattern, staying on
+// the surface when stepping between rows.
+func (g *generator) finish(comp *Heightmap) {
+	rows := comp.rows(g.opt.Stepover)
+	cols := len(comp.Z[0])
+
+	var path []coord.Point
+	for i, r := range rows {
+		c0, c1, dc := 0, cols, 1
+		if i%2 == 1 {
+			c0, c1, dc = cols-1, -1, -1
+		}
+		if i > 0 {
+			// step over along the column we finished the last row on
+			for rr := rows[i-1] + 1; rr < r; rr++ {
+				path = append(path, comp.Point(rr, c0))
+			}
+		}
+		for c := c0; c != c1; c += dc {
+			path = append(path, comp.Point(r, c))
+		}
+	}
+
+	g.rapidZ(g.opt.SafeZ)
+	g.rapidXY(path[0])
+	g.plunge(path[0].Z)
+	g.cut(path)
+	g.rapidZ(g.opt.SafeZ)
+}
+
+// Generate will create gcode to carve img as a relief.
+//
+// The origin is the bottom-left corner of the image, with Z0 at the top of the stock.
+func Generate(img image.Image, opt Options) ([]gcode.Block, error) {
+	err := opt.validate()
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	comp := NewHeightmap(img, opt).Compensate(opt.ToolDiameter/2, opt.BallEnd)
+
+	g := &generator{opt: opt}
+	g.b = append(g.b, gcode.Block{{W: 'G', Arg: 21}, {W: 'G', Arg: 90}})
+	if opt.Stepdown > 0 {
+		g.rough(comp)
+	}
+	g.finish(comp)
+
+	return g.b, nil
+}
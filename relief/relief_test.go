@@ -0,0 +1,67 @@
+package relief
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeightmap_Compensate(t *testing.T) {
+	// single deep cell in the middle of a flat surface
+	h := &Heightmap{
+		Resolution: 1,
+		Z: [][]float64{
+			{0, 0, 0},
+			{0, -5, 0},
+			{0, 0, 0},
+		},
+	}
+
+	// flat tool wider than the hole can't go down at all
+	flat := h.Compensate(1.5, false)
+	assert.Equal(t, 0.0, flat.Z[1][1])
+
+	// ball tool sits on the neighbouring cells
+	ball := h.Compensate(2, true)
+	assert.InDelta(t, -0.26795, ball.Z[1][1], 0.0001)
+
+	// small tool fits
+	small := h.Compensate(0.4, true)
+	assert.Equal(t, -5.0, small.Z[1][1])
+}
+
+func TestGenerate(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	img.SetGray(1, 1, color.Gray{Y: 0})
+
+	opt := Options{
+		Width:        4,
+		Resolution:   1,
+		Depth:        2,
+		ToolDiameter: 0.5,
+		BallEnd:      true,
+		Stepover:     1,
+		Stepdown:     1,
+		SafeZ:        5,
+		FeedRate:     500,
+		PlungeRate:   100,
+	}
+	b, err := Generate(img, opt)
+	assert.NoError(t, err)
+
+	var minZ float64
+	for _, bl := range b {
+		ok, z := bl.Arg('Z')
+		if ok && z < minZ {
+			minZ = z
+		}
+	}
+	assert.Equal(t, -2.0, minZ)
+}
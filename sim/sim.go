@@ -0,0 +1,282 @@
+package sim
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/toolpath"
+)
+
+// Shape is the profile of the cutting end of a tool.
+type Shape string
+
+// Supported tool shapes.
+const (
+	Flat Shape = "flat"
+	Ball Shape = "ball"
+	VBit Shape = "v"
+)
+
+// Tool describes the cutter used for a simulation.
+type Tool struct {
+	Shape    Shape
+	Diameter float64
+
+	// Angle is the included angle of a V-bit, in degrees.
+	Angle float64
+}
+
+// lift will return the height of the tool surface above the tip at distance d from the center.
+func (t Tool) lift(d float64) float64 {
+	r := t.Diameter / 2
+	switch t.Shape {
+	case Ball:
+		return r - math.Sqrt(r*r-d*d)
+	case VBit:
+		return d / math.Tan(t.Angle/2*math.Pi/180)
+	}
+	return 0
+}
+
+// Options configure a simulation.
+type Options struct {
+	// Min and Max are opposite corners of the stock, Max.Z being the top surface.
+	Min, Max coord.Point
+
+	// Resolution is the size of each height field cell in mm.
+	Resolution float64
+
+	Tool Tool
+
+	// FloorZ is the lowest Z that may be cut; anything below is reported as a gouge.
+	FloorZ float64
+
+	// MaxIssues limits the number of issues reported. Defaults to 100.
+	MaxIssues int
+}
+
+func (opt Options) validate() error {
+	if opt.Max.X <= opt.Min.X || opt.Max.Y <= opt.Min.Y || opt.Max.Z <= opt.Min.Z {
+		return errors.New("invalid stock size")
+	}
+	if opt.Resolution <= 0 {
+		return errors.New("resolution must be positive")
+	}
+	if opt.Tool.Diameter <= 0 {
+		return errors.New("tool diameter must be positive")
+	}
+	switch opt.Tool.Shape {
+	case Flat, Ball:
+	case VBit:
+		if opt.Tool.Angle <= 0 || opt.Tool.Angle >= 180 {
+			return errors.New("invalid V-bit angle")
+		}
+	default:
+		return errors.New("unknown tool shape: " + string(opt.Tool.Shape))
+	}
+	return nil
+}
+
+// IssueKind describes a problem found during simulation.
+type IssueKind string
+
+// Issue kinds.
+const (
+	// Gouge is reported when material is removed below the floor.
+	Gouge IssueKind = "gouge"
+	// RapidCut is reported when a rapid move removes material.
+	RapidCut IssueKind = "rapid"
+)
+
+// An Issue is a problem with a single segment.
+type Issue struct {
+	Kind  IssueKind
+	Line  int
+	Point coord.Point
+
+	// Depth is the deepest the tool went below the floor (for gouges) or into the
+	// material (for rapids) during the segment.
+	Depth float64
+}
+
+// Report summarizes the result of a simulation.
+type Report struct {
+	Issues []Issue
+
+	// MinZ, MaxZ and MeanZ describe the final surface.
+	MinZ, MaxZ, MeanZ float64
+
+	// RemovedVolume is the total volume cut, in mm³.
+	RemovedVolume float64
+
+	// CutArea is the fraction (0-1) of the stock surface that was cut.
+	CutArea float64
+}
+
+// Stock is a height field representing the top surface of the material.
+type Stock struct {
+	opt        Options
+	cols, rows int
+	z          []float64
+
+	profile []cell
+}
+
+type cell struct {
+	dc, dr int
+	lift   float64
+}
+
+// NewStock will create an uncut block of stock.
+func NewStock(opt Options) (*Stock, error) {
+	err := opt.validate()
+	if err != nil {
+		return nil, err
+	}
+	if opt.MaxIssues == 0 {
+		opt.MaxIssues = 100
+	}
+
+	s := &Stock{
+		opt:  opt,
+		cols: int(math.Ceil((opt.Max.X - opt.Min.X) / opt.Resolution)),
+		rows: int(math.Ceil((opt.Max.Y - opt.Min.Y) / opt.Resolution)),
+	}
+	s.z = make([]float64, s.cols*s.rows)
+	for i := range s.z {
+		s.z[i] = opt.Max.Z
+	}
+
+	r := opt.Tool.Diameter / 2
+	k := int(math.Ceil(r / opt.Resolution))
+	for dr := -k; dr <= k; dr++ {
+		for dc := -k; dc <= k; dc++ {
+			d := math.Hypot(float64(dc), float64(dr)) * opt.Resolution
+			if d > r {
+				continue
+			}
+			s.profile = append(s.profile, cell{dc: dc, dr: dr, lift: opt.Tool.lift(d)})
+		}
+	}
+
+	return s, nil
+}
+
+// Z will return the height of the surface at a cell, with row 0 at Min.Y.
+func (s *Stock) Z(col, row int) float64 { return s.z[row*s.cols+col] }
+
+// stamp will remove material under the tool with its tip at p, returning
+// the volume removed and the deepest cut into the existing surface.
+func (s *Stock) stamp(p coord.Point) (float64, float64) {
+	c0 := int(math.Floor((p.X - s.opt.Min.X) / s.opt.Resolution))
+	r0 := int(math.Floor((p.Y - s.opt.Min.Y) / s.opt.Resolution))
+
+	var vol, depth float64
+	for _, o := range s.profile {
+		c, r := c0+o.dc, r0+o.dr
+		if c < 0 || c >= s.cols || r < 0 || r >= s.rows {
+			continue
+		}
+		i := r*s.cols + c
+		surface := p.Z + o.lift
+		if s.z[i] <= surface {
+			continue
+		}
+		depth = math.Max(depth, s.z[i]-surface)
+		vol += (s.z[i] - surface) * s.opt.Resolution * s.opt.Resolution
+		s.z[i] = surface
+	}
+	return vol, depth
+}
+
+// Cut will sweep the tool along a segment, returning the volume removed
+// and any issue found.
+func (s *Stock) Cut(seg toolpath.Segment) (float64, *Issue) {
+	dist := seg.End.Sub(seg.Start)
+	n := int(math.Ceil(math.Sqrt(dist.Dot(dist)) / (s.opt.Resolution / 2)))
+	if n < 1 {
+		n = 1
+	}
+
+	var vol, maxDepth, gouge float64
+	var gougeAt, cutAt coord.Point
+	for i := 0; i <= n; i++ {
+		p := seg.Start.Add(dist.Mul(float64(i) / float64(n)))
+		v, depth := s.stamp(p)
+		vol += v
+		if depth > maxDepth {
+			maxDepth = depth
+			cutAt = p
+		}
+		if v > 0 && p.Z < s.opt.FloorZ && s.opt.FloorZ-p.Z > gouge {
+			gouge = s.opt.FloorZ - p.Z
+			gougeAt = p
+		}
+	}
+
+	switch {
+	case gouge > 0:
+		return vol, &Issue{Kind: Gouge, Line: seg.Line, Point: gougeAt, Depth: gouge}
+	case seg.Motion == toolpath.Rapid && maxDepth > coord.Epsilon:
+		return vol, &Issue{Kind: RapidCut, Line: seg.Line, Point: cutAt, Depth: maxDepth}
+	}
+	return vol, nil
+}
+
+// Simulate will run all segments against a new block of stock.
+func Simulate(segs []toolpath.Segment, opt Options) (*Stock, *Report, error) {
+	s, err := NewStock(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rep Report
+	for _, seg := range segs {
+		vol, issue := s.Cut(seg)
+		rep.RemovedVolume += vol
+		if issue != nil && len(rep.Issues) < s.opt.MaxIssues {
+			rep.Issues = append(rep.Issues, *issue)
+		}
+	}
+
+	rep.MinZ, rep.MaxZ = math.Inf(1), math.Inf(-1)
+	var sum float64
+	var cut int
+	for _, z := range s.z {
+		rep.MinZ = math.Min(rep.MinZ, z)
+		rep.MaxZ = math.Max(rep.MaxZ, z)
+		sum += z
+		if z < opt.Max.Z {
+			cut++
+		}
+	}
+	rep.MeanZ = sum / float64(len(s.z))
+	rep.CutArea = float64(cut) / float64(len(s.z))
+
+	return s, &rep, nil
+}
+
+// Image will render the surface as a height map, with lighter being higher.
+// Cells below the floor are shown in red.
+func (s *Stock) Image() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, s.cols, s.rows))
+	low := math.Min(s.opt.Min.Z, s.opt.FloorZ)
+	span := s.opt.Max.Z - low
+	for r := 0; r < s.rows; r++ {
+		for c := 0; c < s.cols; c++ {
+			z := s.Z(c, r)
+			// image Y is down
+			y := s.rows - 1 - r
+			if z < s.opt.FloorZ {
+				img.SetRGBA(c, y, color.RGBA{R: 0xe7, G: 0x4c, B: 0x3c, A: 0xff})
+				continue
+			}
+			v := uint8(math.Max(0, math.Min(1, (z-low)/span)) * 0xff)
+			img.SetRGBA(c, y, color.RGBA{R: v, G: v, B: v, A: 0xff})
+		}
+	}
+	return img
+}
@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/toolpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate(t *testing.T) {
+	opt := Options{
+		Min:        coord.Point{X: 0, Y: 0, Z: -10},
+		Max:        coord.Point{X: 10, Y: 10, Z: 0},
+		Resolution: 0.1,
+		Tool:       Tool{Shape: Flat, Diameter: 2},
+		FloorZ:     -5,
+	}
+
+	segs := []toolpath.Segment{
+		{Start: coord.Point{X: 5, Y: 5, Z: 5}, End: coord.Point{X: 5, Y: 5, Z: 1}, Motion: toolpath.Rapid, Line: 1},
+		{Start: coord.Point{X: 5, Y: 5, Z: 1}, End: coord.Point{X: 5, Y: 5, Z: -1}, Motion: toolpath.Plunge, Line: 2},
+		// rapid through the material
+		{Start: coord.Point{X: 5, Y: 5, Z: -1}, End: coord.Point{X: 8, Y: 5, Z: -1}, Motion: toolpath.Rapid, Line: 3},
+		// too deep
+		{Start: coord.Point{X: 8, Y: 5, Z: -1}, End: coord.Point{X: 8, Y: 5, Z: -6}, Motion: toolpath.Plunge, Line: 4},
+	}
+
+	s, rep, err := Simulate(segs, opt)
+	assert.NoError(t, err)
+
+	if assert.Len(t, rep.Issues, 2) {
+		assert.Equal(t, RapidCut, rep.Issues[0].Kind)
+		assert.Equal(t, 3, rep.Issues[0].Line)
+		assert.InDelta(t, 1, rep.Issues[0].Depth, 0.0001)
+
+		assert.Equal(t, Gouge, rep.Issues[1].Kind)
+		assert.Equal(t, 4, rep.Issues[1].Line)
+		assert.InDelta(t, 1, rep.Issues[1].Depth, 0.0001)
+	}
+
+	assert.Equal(t, -6.0, rep.MinZ)
+	assert.Equal(t, 0.0, rep.MaxZ)
+	assert.Equal(t, -1.0, s.Z(50, 50))
+	assert.Equal(t, 0.0, s.Z(5, 5))
+
+	// 2mm wide slot, 3mm long with round ends at 1mm deep, plus 5mm more at the end
+	assert.InDelta(t, 3.14159+3*2+3.14159*5, rep.RemovedVolume, 1)
+
+	img := s.Image()
+	assert.Equal(t, 100, img.Bounds().Dx())
+}
+
+func TestTool_lift(t *testing.T) {
+	assert.Equal(t, 0.0, Tool{Shape: Flat, Diameter: 2}.lift(1))
+	assert.Equal(t, 1.0, Tool{Shape: Ball, Diameter: 2}.lift(1))
+	assert.InDelta(t, 1.0, Tool{Shape: VBit, Diameter: 2, Angle: 90}.lift(1), 0.0001)
+}
@@ -0,0 +1,60 @@
+package toolpath
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Length returns the distance covered by the segment.
+func (s Segment) Length() float64 {
+	d := s.End.Sub(s.Start)
+	return math.Sqrt(d.Dot(d))
+}
+
+// Duration estimates how long the segment takes, ignoring acceleration.
+//
+// Rapids, and feeds without a rate, move at rapid mm/min.
+func (s Segment) Duration(rapid float64) time.Duration {
+	rate := s.Feed
+	if s.Motion == Rapid || rate <= 0 {
+		rate = rapid
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(s.Length() / rate * float64(time.Minute))
+}
+
+// An Estimate is the expected run time of a program by source line.
+type Estimate struct {
+	lines []int
+	times []time.Duration
+
+	// Total is the estimated time for the whole program.
+	Total time.Duration
+}
+
+// NewEstimate will estimate the run time of segs, with rapids at rapid mm/min.
+func NewEstimate(segs []Segment, rapid float64) *Estimate {
+	e := &Estimate{}
+	for _, s := range segs {
+		e.Total += s.Duration(rapid)
+		if n := len(e.lines); n > 0 && e.lines[n-1] >= s.Line {
+			e.times[n-1] = e.Total
+			continue
+		}
+		e.lines = append(e.lines, s.Line)
+		e.times = append(e.times, e.Total)
+	}
+	return e
+}
+
+// At will return the estimated time to run the program through the end of line.
+func (e *Estimate) At(line int) time.Duration {
+	i := sort.SearchInts(e.lines, line+1)
+	if i == 0 {
+		return 0
+	}
+	return e.times[i-1]
+}
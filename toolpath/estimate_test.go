@@ -0,0 +1,29 @@
+package toolpath
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimate(t *testing.T) {
+	segs := []Segment{
+		{Start: coord.Point{}, End: coord.Point{X: 100}, Motion: Rapid, Line: 2},
+		{Start: coord.Point{X: 100}, End: coord.Point{X: 100, Z: -10}, Motion: Plunge, Feed: 100, Line: 3},
+		{Start: coord.Point{X: 100, Z: -10}, End: coord.Point{X: 130, Y: 40, Z: -10}, Motion: Feed, Feed: 500, Line: 5},
+		{Start: coord.Point{X: 130, Y: 40, Z: -10}, End: coord.Point{X: 130, Y: 90, Z: -10}, Motion: Feed, Feed: 500, Line: 5},
+	}
+
+	assert.Equal(t, 6*time.Second, segs[1].Duration(1000))
+	assert.Equal(t, 50.0, segs[2].Length())
+
+	e := NewEstimate(segs, 1000)
+	assert.Equal(t, 24*time.Second, e.Total)
+	assert.Equal(t, time.Duration(0), e.At(1))
+	assert.Equal(t, 6*time.Second, e.At(2))
+	assert.Equal(t, 12*time.Second, e.At(4))
+	assert.Equal(t, 24*time.Second, e.At(5))
+	assert.Equal(t, 24*time.Second, e.At(100))
+}
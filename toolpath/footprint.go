@@ -0,0 +1,64 @@
+package toolpath
+
+import (
+	"math"
+
+	"github.com/mastercactapus/gcnc/coord"
+)
+
+// Footprint is the XY area covered by the cutting (non-rapid) moves of a toolpath.
+type Footprint struct {
+	// Min and Max are the bounds of the cutting moves.
+	Min, Max coord.Point
+
+	segs   []Segment
+	margin float64
+}
+
+// NewFootprint will find the area within margin of the cutting moves in segs.
+//
+// It returns nil if there are no cutting moves.
+func NewFootprint(segs []Segment, margin float64) *Footprint {
+	f := &Footprint{margin: margin}
+	for _, s := range segs {
+		if s.Motion == Rapid {
+			continue
+		}
+		if len(f.segs) == 0 {
+			f.Min, f.Max = s.Start, s.Start
+		}
+		for _, p := range []coord.Point{s.Start, s.End} {
+			f.Min = coord.Point{X: math.Min(f.Min.X, p.X), Y: math.Min(f.Min.Y, p.Y), Z: math.Min(f.Min.Z, p.Z)}
+			f.Max = coord.Point{X: math.Max(f.Max.X, p.X), Y: math.Max(f.Max.Y, p.Y), Z: math.Max(f.Max.Z, p.Z)}
+		}
+		f.segs = append(f.segs, s)
+	}
+	if len(f.segs) == 0 {
+		return nil
+	}
+	return f
+}
+
+// distanceXY will return the distance from (x, y) to the segment in the XY plane.
+func distanceXY(s Segment, x, y float64) float64 {
+	dx, dy := s.End.X-s.Start.X, s.End.Y-s.Start.Y
+	l := dx*dx + dy*dy
+	if l == 0 {
+		return s.Start.DistanceXY(x, y)
+	}
+	t := math.Max(0, math.Min(1, ((x-s.Start.X)*dx+(y-s.Start.Y)*dy)/l))
+	return math.Hypot(s.Start.X+dx*t-x, s.Start.Y+dy*t-y)
+}
+
+// Contains will check if (x, y) is within the margin of a cutting move.
+func (f *Footprint) Contains(x, y float64) bool {
+	if x < f.Min.X-f.margin || x > f.Max.X+f.margin || y < f.Min.Y-f.margin || y > f.Max.Y+f.margin {
+		return false
+	}
+	for _, s := range f.segs {
+		if distanceXY(s, x, y) <= f.margin {
+			return true
+		}
+	}
+	return false
+}
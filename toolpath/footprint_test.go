@@ -0,0 +1,28 @@
+package toolpath
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFootprint(t *testing.T) {
+	segs := []Segment{
+		{Start: coord.Point{X: -50, Y: -50, Z: 5}, End: coord.Point{X: 0, Y: 0, Z: 5}, Motion: Rapid},
+		{Start: coord.Point{X: 0, Y: 0, Z: 5}, End: coord.Point{X: 0, Y: 0, Z: -1}, Motion: Plunge},
+		{Start: coord.Point{X: 0, Y: 0, Z: -1}, End: coord.Point{X: 20, Y: 0, Z: -1}, Motion: Feed},
+		{Start: coord.Point{X: 20, Y: 0, Z: -1}, End: coord.Point{X: 20, Y: 10, Z: -1}, Motion: Feed},
+	}
+
+	f := NewFootprint(segs, 2)
+	assert.Equal(t, coord.Point{X: 0, Y: 0, Z: -1}, f.Min)
+	assert.Equal(t, coord.Point{X: 20, Y: 10, Z: 5}, f.Max)
+
+	assert.True(t, f.Contains(10, 1.5))
+	assert.True(t, f.Contains(21, 8))
+	assert.False(t, f.Contains(5, 8))
+	assert.False(t, f.Contains(-25, -25))
+
+	assert.Nil(t, NewFootprint(segs[:1], 2))
+}
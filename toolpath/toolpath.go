@@ -0,0 +1,136 @@
+package toolpath
+
+import (
+	"io"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+)
+
+// Motion is the type of movement for a Segment.
+type Motion byte
+
+// Motion types.
+const (
+	Rapid Motion = iota
+	Feed
+	Plunge
+	ArcCW
+	ArcCCW
+)
+
+func (m Motion) String() string {
+	switch m {
+	case Rapid:
+		return "rapid"
+	case Feed:
+		return "feed"
+	case Plunge:
+		return "plunge"
+	case ArcCW:
+		return "arc-cw"
+	case ArcCCW:
+		return "arc-ccw"
+	}
+	return "unknown"
+}
+
+// Segment is a single straight move in machine coordinates.
+//
+// Arcs are returned as a series of segments within gcode.ArcTolerance of the true path.
+type Segment struct {
+	Start, End coord.Point
+	Motion     Motion
+
+	// Feed is the feed rate in mm/min, or 0 for rapids.
+	Feed float64
+
+	// Line is the source line of the block, if known.
+	Line int
+}
+
+// Interpreter will run gcode through a VM and return the resulting moves.
+//
+// If the Reader is a gcode.LineReader, segments will include the source line.
+type Interpreter struct {
+	vm *gcode.VM
+	r  gcode.Reader
+}
+
+// NewInterpreter will create a new Interpreter starting at mPos with the given work coordinate offset.
+func NewInterpreter(r gcode.Reader, mPos, wco coord.Point) *Interpreter {
+	vm := gcode.NewVM()
+	vm.SetMPos(mPos)
+	vm.SetWCO(wco)
+	return &Interpreter{vm: vm, r: r}
+}
+
+// Next will return the segments for the next block that results in motion.
+func (i *Interpreter) Next() ([]Segment, error) {
+	for {
+		b, err := i.r.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		start := i.vm.MPos()
+		err = i.vm.Run(b)
+		if err != nil {
+			return nil, err
+		}
+		end := i.vm.MPos()
+
+		var line int
+		if lr, ok := i.r.(gcode.LineReader); ok {
+			line = lr.Line()
+		}
+
+		if ok, arc := i.vm.Arc(); ok {
+			s := Segment{Start: start, Feed: i.vm.Feed(), Line: line, Motion: ArcCCW}
+			if arc.Clockwise {
+				s.Motion = ArcCW
+			}
+			pts := arc.Points(gcode.ArcTolerance)
+			res := make([]Segment, len(pts))
+			for n, p := range pts {
+				s.End = p
+				res[n] = s
+				s.Start = p
+			}
+			return res, nil
+		}
+
+		if start.Equal(end) {
+			continue
+		}
+
+		s := Segment{Start: start, End: end, Line: line}
+		if i.vm.Motion() == 0 {
+			s.Motion = Rapid
+		} else {
+			s.Feed = i.vm.Feed()
+			s.Motion = Feed
+			if start.X == end.X && start.Y == end.Y && end.Z < start.Z {
+				s.Motion = Plunge
+			}
+		}
+
+		return []Segment{s}, nil
+	}
+}
+
+// ReadAll will interpret the entire program.
+func ReadAll(r gcode.Reader, mPos, wco coord.Point) ([]Segment, error) {
+	i := NewInterpreter(r, mPos, wco)
+	var res []Segment
+	for {
+		s, err := i.Next()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s...)
+	}
+}
@@ -0,0 +1,27 @@
+package toolpath
+
+import (
+	"testing"
+
+	"github.com/mastercactapus/gcnc/coord"
+	"github.com/mastercactapus/gcnc/gcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAll(t *testing.T) {
+	r := &gcode.BlocksReader{Blocks: gcode.MustParse(`
+		G21 G90
+		G0 X10 Y10
+		G1 Z-1 F100
+		G1 X20 F500
+		M5
+	`)}
+
+	segs, err := ReadAll(r, coord.Point{Z: 10}, coord.Point{Z: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, []Segment{
+		{Start: coord.Point{Z: 10}, End: coord.Point{X: 10, Y: 10, Z: 10}, Motion: Rapid},
+		{Start: coord.Point{X: 10, Y: 10, Z: 10}, End: coord.Point{X: 10, Y: 10, Z: 4}, Motion: Plunge, Feed: 100},
+		{Start: coord.Point{X: 10, Y: 10, Z: 4}, End: coord.Point{X: 20, Y: 10, Z: 4}, Motion: Feed, Feed: 500},
+	}, segs)
+}